@@ -14,7 +14,10 @@ package main
 */
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -22,6 +25,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/moia-oss/aws-cfg-generator/pkg/cmd"
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
 )
 
 func main() {
@@ -37,9 +41,24 @@ func main() {
 	} else {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
+	// cancel discovery on Ctrl-C instead of dying in the middle of writing a config
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cli.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		runCtx, cancel = context.WithTimeout(runCtx, cli.Timeout)
+		defer cancel()
+	}
+
+	ctx.BindTo(runCtx, (*context.Context)(nil))
+
 	err := ctx.Run(cli)
 	if err != nil {
-		log.Panic().Err(err).Msgf("unexpected CLI error")
+		stop()
+		log.Error().Err(err).Str("kind", util.ErrorKindOf(err).String()).Msg("could not generate config")
+		os.Exit(util.ExitCode(err))
 	}
 
 	elapsed := time.Since(start)
@@ -0,0 +1,128 @@
+package cmd
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+)
+
+// nolint:govet // we need the bare `required` tag here
+type AWSCLICmd struct {
+	SourceProfile        string `help:"The profile that your credentials should come from" default:"default"`
+	CredentialSource     string `help:"Assume the roles with the credentials of the host instead of a source profile: Ec2InstanceMetadata, EcsContainer or Environment"`
+	Region               string `help:"Override the region configured with your source profile"`
+	ConfigPath           string `help:"Where to load/save the config, e.g. ~/.aws/config" required`
+	KeepCustomConfig     bool   `help:"Retains any custom profiles or settings. Set to false to remove everything except the source profile and generated config" default:true`
+	UseRoleNameInProfile bool   `help:"Append the role name to the profile name" default:false`
+	Inventory            string `help:"Generate from an inventory snapshot written by the export command instead of calling AWS" type:"existingfile"`
+	Create               bool   `help:"Create the config if it doesn't exist" default:"false"`
+	RoleSessionName      string `help:"The name of the sessions of the assumed roles, shown in CloudTrail"`
+	MFASerial            string `name:"mfa-serial" help:"The serial number of your MFA device, defaults to the mfa_serial of your source profile"`
+	DurationSeconds      int    `help:"How long the sessions of the assumed roles last, between 900 and 43200 seconds" default:"0"`
+
+	outputFlags `embed:""`
+	backupFlags `embed:""`
+}
+
+func (ac *AWSCLICmd) Run(cli *CLI, ctx context.Context) error {
+	if err := ac.validate(); err != nil {
+		return err
+	}
+
+	gen, err := cli.generation()
+	if err != nil {
+		return err
+	}
+
+	inventory, err := cli.discover(ctx, cli.AWSCLI.Inventory, gen.needsAccountDetails())
+	if err != nil {
+		return err
+	}
+
+	if ac.RoleSessionName == "" {
+		gen.roleSessionName, err = cli.roleSessionName(ctx, ac.Inventory, inventory, gen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return generateAWSCLIProfile(inventory, cli.AWSCLI, gen)
+}
+
+// validate checks the keys given as flags like those of profile key rules
+func (ac AWSCLICmd) validate() error {
+	keys := map[string]string{}
+
+	if ac.CredentialSource != "" {
+		keys["credential_source"] = ac.CredentialSource
+	}
+
+	if ac.RoleSessionName != "" {
+		keys["role_session_name"] = ac.RoleSessionName
+	}
+
+	if ac.MFASerial != "" {
+		keys["mfa_serial"] = ac.MFASerial
+	}
+
+	if ac.DurationSeconds != 0 {
+		keys["duration_seconds"] = strconv.Itoa(ac.DurationSeconds)
+	}
+
+	return util.ValidateProfileKeys(keys)
+}
+
+func (ac AWSCLICmd) options(gen generation) generator.AWSCLIOptions {
+	output := ac.output()
+	output.Backups = ac.Backups
+
+	// the flag takes precedence over --role-session-name-template
+	roleSessionName := ac.RoleSessionName
+	if roleSessionName == "" {
+		roleSessionName = gen.roleSessionName
+	}
+
+	return generator.AWSCLIOptions{
+		VaultOptions: generator.VaultOptions{
+			SourceProfile:        ac.SourceProfile,
+			Region:               ac.Region,
+			RoleSessionName:      roleSessionName,
+			ConfigPath:           ac.ConfigPath,
+			KeepCustomConfig:     ac.KeepCustomConfig,
+			UseRoleNameInProfile: ac.UseRoleNameInProfile,
+			Order:                gen.order,
+			Usage:                gen.usage,
+			Collisions:           gen.collisions,
+			NameTemplate:         gen.nameTemplate,
+			NameRules:            gen.nameRules,
+			Aliases:              gen.aliases,
+			Filters:              gen.filters,
+			Stages:               gen.stages,
+			ProfileKeys:          gen.profileKeys,
+			Output:               output,
+			Create:               ac.Create,
+		},
+		CredentialSource: ac.CredentialSource,
+		MFASerial:        ac.MFASerial,
+		DurationSeconds:  ac.DurationSeconds,
+	}
+}
+
+func generateAWSCLIProfile(inventory *util.Inventory, cmdOptions AWSCLICmd, gen generation) error {
+	return generator.GenerateAWSCLI(inventory, cmdOptions.options(gen))
+}
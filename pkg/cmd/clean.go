@@ -0,0 +1,46 @@
+package cmd
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
+)
+
+// nolint:govet // we need the bare `required` tag here
+type CleanCmd struct {
+	VaultConfigPath string `help:"The aws-vault config to remove the generated profiles from" required`
+
+	outputFlags `embed:""`
+	backupFlags `embed:""`
+}
+
+func (cc *CleanCmd) Run() error {
+	output := cc.output()
+	output.Backups = cc.Backups
+
+	removed, err := generator.CleanVault(cc.VaultConfigPath, output)
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range removed {
+		log.Debug().Str("profile", profile).Msg("Removed generated profile")
+	}
+
+	log.Info().Str("file-path", cc.VaultConfigPath).Msgf("Removed %d generated profiles", len(removed))
+
+	return nil
+}
@@ -13,11 +13,355 @@ package cmd
    limitations under the License.
 */
 
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sso"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+)
+
 // nolint:govet // we need the bare `cmd` tag here
 type CLI struct {
 	Vault       VaultCmd       `cmd help:"generates a config for aws-vault"`
+	AWSCLI      AWSCLICmd      `cmd name:"aws-cli" help:"generates a config for the AWS CLI and SDKs, without the keys only aws-vault understands"`
 	SwitchRoles SwitchRolesCmd `cmd help:"generates a config for aws-extend-switch-roles"`
+	Export      ExportCmd      `cmd help:"writes the discovered accounts and roles to an inventory snapshot"`
+	Clean       CleanCmd       `cmd help:"removes all profiles generated by aws-cfg-generator from an aws-vault config"`
+	Restore     RestoreCmd     `cmd help:"lists the backups of an aws-vault config, or restores one of them"`
 	Debug       bool           `help:"set the log level to debug" default:"false"`
 	Role        string         `help:"If set, then a profile with this role will be generated for every account in the organization, in addition to the roles that the user has permissions to assume"`
 	Ordered     bool           `help:"disable ordering based on alphabet, stage and uniqueness" default:"true"`
+	Order       string         `help:"How to order the profiles: alphabetical, account (stages of an account in pipeline order), role, ou or recent (most recently used with the AWS CLI first, not tracked for aws-vault)" enum:"alphabetical,account,role,ou,recent" default:"alphabetical"`
+	OnCollision string         `help:"How profiles of different roles with the same name are told apart: append-role-name, append-account-id or fail" enum:"append-role-name,append-account-id,fail" default:"append-role-name"`
+
+	ProfileNameTemplate     string `help:"Render profile names from this Go template, e.g. '{{.Stage}}-{{.Account}}-{{.Role | lower}}'"`
+	RoleSessionNameTemplate string `help:"Render the role_session_name of the aws-vault and AWS CLI profiles from this Go template over your identity, e.g. '{{.UserName}}@{{.Hostname}}'"`
+	Config                  string `help:"Path to a JSON file with rules for the generated profiles, e.g. to normalize names" type:"existingfile"`
+	Aliases                 string `help:"Path to a JSON file mapping account IDs to a name, stage and color that take precedence over the organization" type:"existingfile"`
+
+	IncludeAccount     []string `help:"Only generate profiles for these account IDs" placeholder:"ID"`
+	ExcludeAccount     []string `help:"Don't generate profiles for these account IDs" placeholder:"ID"`
+	IncludeAccountName []string `help:"Only generate profiles for accounts with names matching these globs, or regular expressions enclosed in slashes" placeholder:"PATTERN" sep:"none"`
+	ExcludeAccountName []string `help:"Don't generate profiles for accounts with names matching these globs, or regular expressions enclosed in slashes" placeholder:"PATTERN" sep:"none"`
+	IncludeRole        []string `help:"Only generate profiles for roles with names matching these globs" placeholder:"GLOB"`
+	ExcludeRole        []string `help:"Don't generate profiles for roles with names matching these globs" placeholder:"GLOB"`
+	IncludeOU          []string `help:"Only generate profiles for accounts in these OU paths, e.g. workloads/payments" placeholder:"GLOB"`
+	ExcludeOU          []string `help:"Don't generate profiles for accounts in these OU paths" placeholder:"GLOB"`
+	IncludeTag         []string `help:"Only generate profiles for accounts with one of these tags, given as key or key=value" placeholder:"TAG"`
+	ExcludeTag         []string `help:"Don't generate profiles for accounts with one of these tags, given as key or key=value" placeholder:"TAG"`
+	IncludeStage       []string `help:"Only generate profiles for accounts of these stages" placeholder:"STAGE"`
+	ExcludeStage       []string `help:"Don't generate profiles for accounts of these stages" placeholder:"STAGE"`
+
+	CacheTTL time.Duration `help:"How long discovered roles and accounts are cached, set to 0 to disable the cache" default:"15m"`
+	Refresh  bool          `help:"Ignore cached discovery results and rediscover roles and accounts" default:"false"`
+
+	Timeout     time.Duration `help:"Abort if discovery takes longer than this, set to 0 to wait forever" default:"0s"`
+	CallTimeout time.Duration `help:"Abort a single AWS API call that takes longer than this, set to 0 to wait forever" default:"1m"`
+
+	APIRegion             string `help:"The region used for AWS API calls, defaults to the region of your environment or shared config"`
+	CABundle              string `help:"Path to a PEM encoded CA bundle used to verify TLS connections to AWS" type:"path"`
+	STSEndpoint           string `help:"Custom endpoint URL for STS, e.g. a local stand-in like http://localhost:5000"`
+	IAMEndpoint           string `help:"Custom endpoint URL for IAM"`
+	OrganizationsEndpoint string `help:"Custom endpoint URL for Organizations"`
+	SSOEndpoint           string `help:"Custom endpoint URL for the SSO portal used to fetch SSO credentials"`
+
+	Record string `help:"Record every AWS API call and its result to this file, e.g. to attach it to a bug report" type:"path"`
+	Replay string `help:"Serve the AWS API calls from a file written by --record instead of calling AWS" type:"existingfile"`
+
+	// the AWS context of the command run, see awsContext
+	awsCtx *util.AWSContext
+}
+
+// outputFlags control how the commands writing a config write it
+type outputFlags struct {
+	DryRun        bool   `help:"Print a diff of the changes instead of writing the config" default:"false"`
+	ChangeSummary string `help:"Write a JSON summary of the changed profiles and keys to this file, - for stdout" placeholder:"PATH"`
+
+	// where the diff of a dry run is printed, stdout if nil
+	diffOutput io.Writer
+}
+
+// backupFlags control the backups of the commands overwriting an aws-vault config
+type backupFlags struct {
+	Backups int `help:"How many timestamped backups of the config are kept, set to 0 to disable them" default:"5"`
+}
+
+func (f outputFlags) output() generator.OutputOptions {
+	return generator.OutputOptions{
+		DryRun:        f.DryRun,
+		DiffOutput:    f.diffOutput,
+		Color:         colorOutput(),
+		ChangeSummary: f.ChangeSummary,
+	}
+}
+
+// colorOutput reports whether stdout is a terminal that may be colored, see https://no-color.org
+func colorOutput() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	info, err := os.Stdout.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// generation holds the global flags that shape the generated profiles of every output format
+type generation struct {
+	order        util.OrderStrategy
+	usage        util.RoleUsage
+	collisions   util.CollisionStrategy
+	nameTemplate *util.ProfileNameTemplate
+	nameRules    *util.NameRules
+	aliases      util.Aliases
+	filters      *util.Filters
+	stages       *util.StageModel
+	profileKeys  util.ProfileKeyRules
+	// the role session name template, and the role session name rendered from it once the caller is known
+	sessionNameTemplate *util.SessionNameTemplate
+	roleSessionName     string
+}
+
+func (cli *CLI) generation() (gen generation, err error) {
+	gen = generation{
+		collisions: util.CollisionStrategy(cli.OnCollision),
+	}
+
+	if cli.Ordered {
+		gen.order = util.OrderStrategy(cli.Order)
+	}
+
+	if gen.order == util.OrderRecent {
+		gen.usage, err = readRoleUsage()
+		if err != nil {
+			return gen, err
+		}
+	}
+
+	if cli.ProfileNameTemplate != "" {
+		gen.nameTemplate, err = util.ParseProfileNameTemplate(cli.ProfileNameTemplate)
+		if err != nil {
+			return gen, err
+		}
+	}
+
+	if cli.RoleSessionNameTemplate != "" {
+		gen.sessionNameTemplate, err = util.ParseSessionNameTemplate(cli.RoleSessionNameTemplate)
+		if err != nil {
+			return gen, err
+		}
+	}
+
+	gen.filters = cli.filters()
+
+	if cli.Config != "" {
+		config, err := util.ReadConfig(cli.Config)
+		if err != nil {
+			return gen, err
+		}
+
+		gen.nameRules = config.NameRules
+		gen.filters = config.Filters.Merge(gen.filters)
+		gen.profileKeys = config.ProfileKeys
+
+		gen.stages, err = config.StageModel()
+		if err != nil {
+			return gen, err
+		}
+	}
+
+	if gen.filters != nil {
+		if err := gen.filters.Compile(); err != nil {
+			return gen, err
+		}
+	}
+
+	if cli.Aliases != "" {
+		gen.aliases, err = util.ReadAliases(cli.Aliases)
+		if err != nil {
+			return gen, err
+		}
+	}
+
+	return gen, nil
+}
+
+// needsAccountDetails reports whether the profile names, filters, stages, order or profile keys need the
+// organizational units or tags of accounts
+func (gen generation) needsAccountDetails() bool {
+	return gen.nameTemplate != nil && gen.nameTemplate.NeedsAccountDetails() ||
+		gen.filters.NeedsAccountDetails() ||
+		gen.stages.NeedsAccountDetails() ||
+		gen.order == util.OrderByOU ||
+		gen.profileKeys.NeedsAccountDetails()
+}
+
+// roleSessionName renders the role session name template of gen, if any, over the identity of the caller. When
+// generating from an inventory snapshot, the caller is the one who took it, and the tags of IAM users are unknown.
+func (cli *CLI) roleSessionName(ctx context.Context, inventoryFile string, inventory *util.Inventory,
+	gen generation) (string, error) {
+	if gen.sessionNameTemplate == nil {
+		return "", nil
+	}
+
+	var data util.SessionNameData
+
+	if inventoryFile != "" {
+		if inventory.CallerArn == "" {
+			return "", util.ConfigError(nil, "the inventory %s doesn't record the caller for the role session name",
+				inventoryFile)
+		}
+
+		if gen.sessionNameTemplate.NeedsUserTags() {
+			log.Warn().Msg("the tags of the caller are only looked up when discovering from AWS, " +
+				"the role session name is rendered without them")
+		}
+
+		data = util.NewSessionNameData(inventory.CallerArn)
+	} else {
+		awsContext, err := cli.awsContext()
+		if err != nil {
+			return "", err
+		}
+
+		data, err = awsContext.WithContext(ctx).GetSessionNameData(gen.sessionNameTemplate.NeedsUserTags())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	name, err := gen.sessionNameTemplate.Render(data)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().Str("role-session-name", name).Msg("Rendered role session name")
+
+	return name, nil
+}
+
+// readRoleUsage reads when roles were last used from the credential cache of the AWS CLI
+func readRoleUsage() (util.RoleUsage, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, util.IOError(err, "could not find home directory")
+	}
+
+	return util.ReadRoleUsage(filepath.Join(home, ".aws", "cli", "cache"))
+}
+
+// filters returns the filters given as flags, or nil if there are none
+func (cli *CLI) filters() *util.Filters {
+	filters := &util.Filters{
+		IncludeAccounts:     cli.IncludeAccount,
+		ExcludeAccounts:     cli.ExcludeAccount,
+		IncludeAccountNames: cli.IncludeAccountName,
+		ExcludeAccountNames: cli.ExcludeAccountName,
+		IncludeRoles:        cli.IncludeRole,
+		ExcludeRoles:        cli.ExcludeRole,
+		IncludeOUs:          cli.IncludeOU,
+		ExcludeOUs:          cli.ExcludeOU,
+		IncludeTags:         cli.IncludeTag,
+		ExcludeTags:         cli.ExcludeTag,
+		IncludeStages:       cli.IncludeStage,
+		ExcludeStages:       cli.ExcludeStage,
+	}
+
+	if reflect.DeepEqual(filters, &util.Filters{}) {
+		return nil
+	}
+
+	return filters
+}
+
+func (cli *CLI) discoverOptions(accountDetails bool) generator.DiscoverOptions {
+	opts := generator.DiscoverOptions{
+		Role:           cli.Role,
+		AccountDetails: accountDetails,
+	}
+
+	// recording or replaying requires the API calls of a full discovery
+	if cli.CacheTTL > 0 && cli.Record == "" && cli.Replay == "" {
+		cache, err := util.NewCache(cli.CacheTTL, cli.Refresh)
+		if err != nil {
+			log.Warn().Err(err).Msg("discovery results will not be cached")
+		}
+
+		opts.Cache = cache
+	}
+
+	return opts
+}
+
+// discover reads the inventory from a snapshot file if one is given, and from AWS otherwise.
+// It fails if ctx is done by the time discovery finishes, so that no config is written after an interrupt.
+func (cli *CLI) discover(ctx context.Context, inventoryFile string, accountDetails bool) (inventory *util.Inventory, err error) {
+	if inventoryFile != "" {
+		inventory, err = generator.LoadInventory(inventoryFile, cli.discoverOptions(accountDetails))
+	} else {
+		inventory, err = cli.discoverFromAWS(ctx, accountDetails)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Err() != nil {
+		return nil, util.ContextError(ctx.Err())
+	}
+
+	return inventory, nil
+}
+
+func (cli *CLI) discoverFromAWS(ctx context.Context, accountDetails bool) (*util.Inventory, error) {
+	awsContext, err := cli.awsContext()
+	if err != nil {
+		return nil, err
+	}
+
+	return generator.Discover(ctx, awsContext, cli.discoverOptions(accountDetails))
+}
+
+// awsContext returns the AWS context of the command run. It is built on first use, so that generating from an
+// inventory snapshot needs no credentials, and only once, so that all API calls of a run share a session and are
+// recorded to the same --record file.
+func (cli *CLI) awsContext() (*util.AWSContext, error) {
+	if cli.awsCtx == nil {
+		awsContext, err := util.GetAWSContext(cli.awsConfig())
+		if err != nil {
+			return nil, err
+		}
+
+		cli.awsCtx = awsContext
+	}
+
+	return cli.awsCtx, nil
+}
+
+func (cli *CLI) awsConfig() util.AWSConfig {
+	return util.AWSConfig{
+		Region:      cli.APIRegion,
+		CABundle:    cli.CABundle,
+		CallTimeout: cli.CallTimeout,
+		Endpoints: map[string]string{
+			sts.EndpointsID:           cli.STSEndpoint,
+			iam.EndpointsID:           cli.IAMEndpoint,
+			organizations.EndpointsID: cli.OrganizationsEndpoint,
+			sso.EndpointsID:           cli.SSOEndpoint,
+		},
+		Record: cli.Record,
+		Replay: cli.Replay,
+	}
 }
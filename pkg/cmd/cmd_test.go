@@ -14,10 +14,19 @@ package cmd
 */
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
 )
 
 func setup(configFileContents string) (filename string) {
@@ -39,7 +48,7 @@ type TestCase struct {
 	it             string
 	originalConfig string
 	expectedConfig string
-	run            func(filename string)
+	run            func(filename string) error
 }
 
 func TestAll(t *testing.T) {
@@ -53,19 +62,20 @@ func TestAll(t *testing.T) {
 			originalConfig: `[default]`,
 			expectedConfig: `[default]
 
+# generated by aws-cfg-generator
 [profile my-account]
 role_arn        = arn:aws:iam::12345:role/my-role
 source_profile  = default
 include_profile = default
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, roleArns, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: false,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -74,19 +84,20 @@ include_profile = default
 			originalConfig: `[default]`,
 			expectedConfig: `[default]
 
+# generated by aws-cfg-generator
 [profile my-account]
 role_arn        = arn:aws:iam::12345:role/my-role
 source_profile  = default
 include_profile = default
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, []string{"foobar", "arn:aws:iam::12345:role/my-role"}, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: []string{"foobar", "arn:aws:iam::12345:role/my-role"}}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: false,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -95,19 +106,20 @@ include_profile = default
 			originalConfig: `[default]`,
 			expectedConfig: `[default]
 
+# generated by aws-cfg-generator
 [profile 67890]
 role_arn        = arn:aws:iam::67890:role/my-role
 source_profile  = default
 include_profile = default
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, []string{"arn:aws:iam::67890:role/my-role"}, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: []string{"arn:aws:iam::67890:role/my-role"}}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: false,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -116,19 +128,20 @@ include_profile = default
 			originalConfig: "[profile my-profile]",
 			expectedConfig: `[profile my-profile]
 
+# generated by aws-cfg-generator
 [profile my-account]
 role_arn        = arn:aws:iam::12345:role/my-role
 source_profile  = my-profile
 include_profile = my-profile
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, roleArns, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        "my-profile",
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: false,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -137,19 +150,20 @@ include_profile = my-profile
 			originalConfig: `[default]`,
 			expectedConfig: `[default]
 
+# generated by aws-cfg-generator
 [profile my-account_my-role]
 role_arn        = arn:aws:iam::12345:role/my-role
 source_profile  = default
 include_profile = default
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, roleArns, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: true,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -177,14 +191,133 @@ include_profile = default
 
 [profile some-other-profile]
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, roleArns, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     true,
 					UseRoleNameInProfile: true,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe: "vault",
+			it:       "orders the profiles of an existing config",
+			originalConfig: `[default]
+
+# generated by aws-cfg-generator
+[profile other-account]
+role_arn        = arn:aws:iam::67890:role/my-role
+source_profile  = default
+include_profile = default
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+`,
+			expectedConfig: `[default]
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+
+# generated by aws-cfg-generator
+[profile other-account]
+role_arn        = arn:aws:iam::67890:role/my-role
+source_profile  = default
+include_profile = default
+`,
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{
+					Accounts: map[string]string{"12345": "my-account", "67890": "other-account"},
+					RoleArns: []string{"arn:aws:iam::67890:role/my-role", "arn:aws:iam::12345:role/my-role"},
+				}, VaultCmd{
+					VaultConfigPath:  filename,
+					SourceProfile:    "default",
+					KeepCustomConfig: false,
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe: "vault",
+			it:       "updates and prunes only generated profiles",
+			originalConfig: `[default]
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+region          = eu-west-1
+
+# generated by aws-cfg-generator
+[profile lost-account]
+role_arn        = arn:aws:iam::67890:role/my-role
+source_profile  = default
+include_profile = default
+
+[profile hand-written]
+role_arn       = arn:aws:iam::67890:role/my-role
+source_profile = default
+`,
+			expectedConfig: `[default]
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+
+[profile hand-written]
+role_arn       = arn:aws:iam::67890:role/my-role
+source_profile = default
+`,
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
+					VaultConfigPath:  filename,
+					SourceProfile:    `default`,
+					KeepCustomConfig: true,
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe: "vault",
+			it:       "keeps the formatting and comments of hand-written profiles",
+			originalConfig: `; my config
+[default]
+region=eu-central-1 ; Frankfurt
+
+[profile hand-written]
+# the role
+role_arn=arn:aws:iam::67890:role/my-role
+source_profile=default
+`,
+			expectedConfig: `; my config
+[default]
+region=eu-central-1 ; Frankfurt
+
+[profile hand-written]
+# the role
+role_arn=arn:aws:iam::67890:role/my-role
+source_profile=default
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+`,
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
+					VaultConfigPath:  filename,
+					SourceProfile:    `default`,
+					KeepCustomConfig: true,
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -204,19 +337,20 @@ include_profile = default
 			expectedConfig: `[default]
 output = json
 
+# generated by aws-cfg-generator
 [profile my-account_my-role]
 role_arn        = arn:aws:iam::12345:role/my-role
 source_profile  = default
 include_profile = default
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, roleArns, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: true,
 					Region:               "",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -225,20 +359,204 @@ include_profile = default
 			originalConfig: `[default]`,
 			expectedConfig: `[default]
 
+# generated by aws-cfg-generator
 [profile my-account_my-role]
 role_arn        = arn:aws:iam::12345:role/my-role
 source_profile  = default
 include_profile = default
 region          = eu-central-1
 `,
-			run: func(filename string) {
-				generateVaultProfile(accountMap, roleArns, VaultCmd{
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, VaultCmd{
 					VaultConfigPath:      filename,
 					SourceProfile:        `default`,
 					KeepCustomConfig:     false,
 					UseRoleNameInProfile: true,
 					Region:               "eu-central-1",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe:       "vault",
+			it:             "records which policies granted a profile",
+			originalConfig: `[default]`,
+			expectedConfig: `[default]
+
+# generated by aws-cfg-generator
+# granted by group admins, policy admin-access, statement AllowAdmin
+# granted by group developers, policy arn:aws:iam::11111:policy/dev-access
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+`,
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{
+					Accounts: accountMap,
+					RoleArns: roleArns,
+					Grants: []util.Grant{
+						{RoleArn: roleArns[0], Group: "developers", Policy: "arn:aws:iam::11111:policy/dev-access"},
+						{RoleArn: roleArns[0], Group: "admins", Policy: "admin-access", Sid: "AllowAdmin"},
+						{RoleArn: roleArns[0], Group: "developers", Policy: "arn:aws:iam::11111:policy/dev-access"},
+					},
+				}, VaultCmd{
+					VaultConfigPath: filename,
+					SourceProfile:   `default`,
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe:       "vault",
+			it:             "tells apart roles of the same account",
+			originalConfig: `[default]`,
+			expectedConfig: `[default]
+
+# generated by aws-cfg-generator
+[profile my-account_my-role]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+
+# generated by aws-cfg-generator
+[profile my-account_other-role]
+role_arn        = arn:aws:iam::12345:role/other-role
+source_profile  = default
+include_profile = default
+`,
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{
+					Accounts: accountMap,
+					RoleArns: []string{"arn:aws:iam::12345:role/other-role", roleArns[0], roleArns[0]},
+				}, VaultCmd{
+					VaultConfigPath: filename,
+					SourceProfile:   `default`,
+				}, generation{order: util.OrderAlphabetical, collisions: util.CollisionAppendRoleName})
+			},
+		},
+		{
+			describe: "vault",
+			it:       "sets the keys of matching profile key rules",
+			originalConfig: `[default]
+[profile production]
+region = eu-central-1
+[profile other]`,
+			expectedConfig: `[default]
+[profile production]
+region = eu-central-1
+
+# generated by aws-cfg-generator
+[profile payments-dev]
+role_arn        = arn:aws:iam::12345:role/admin
+source_profile  = default
+include_profile = default
+region          = eu-west-1
+mfa_serial      = arn:aws:iam::11111:mfa/jane
+
+# generated by aws-cfg-generator
+[profile payments-prd]
+role_arn         = arn:aws:iam::67890:role/admin
+source_profile   = production
+include_profile  = production
+region           = eu-west-1
+duration_seconds = 900
+mfa_serial       = arn:aws:iam::11111:mfa/jane
+`,
+			run: func(filename string) error {
+				return generateVaultProfile(&util.Inventory{
+					Accounts: map[string]string{"12345": "payments-dev", "67890": "payments-prd"},
+					RoleArns: []string{"arn:aws:iam::12345:role/admin", "arn:aws:iam::67890:role/admin"},
+				}, VaultCmd{
+					VaultConfigPath: filename,
+					SourceProfile:   "default",
+					Region:          "eu-west-1",
+				}, generation{order: util.OrderAlphabetical, profileKeys: util.ProfileKeyRules{
+					{Match: &util.Filters{IncludeRoles: []string{"admin"}}, Keys: map[string]string{"mfa_serial": "arn:aws:iam::11111:mfa/jane"}},
+					{Match: &util.Filters{IncludeStages: []string{"prd"}}, Keys: map[string]string{
+						"duration_seconds": "900",
+						"source_profile":   "production",
+					}},
+				}})
+			},
+		},
+		{
+			describe: "aws-cli",
+			it:       "generates a profile without aws-vault keys, copying mfa_serial from the source profile",
+			originalConfig: `[default]
+mfa_serial = arn:aws:iam::11111:mfa/jane
+`,
+			expectedConfig: `[default]
+mfa_serial = arn:aws:iam::11111:mfa/jane
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn          = arn:aws:iam::12345:role/my-role
+source_profile    = default
+role_session_name = jane
+mfa_serial        = arn:aws:iam::11111:mfa/jane
+duration_seconds  = 3600
+region            = eu-central-1
+`,
+			run: func(filename string) error {
+				return generateAWSCLIProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, AWSCLICmd{
+					ConfigPath:       filename,
+					SourceProfile:    "default",
+					Region:           "eu-central-1",
+					KeepCustomConfig: true,
+					RoleSessionName:  "jane",
+					DurationSeconds:  3600,
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe: "aws-cli",
+			it:       "lets profile keys override the flags",
+			originalConfig: `[default]
+[profile production]
+`,
+			expectedConfig: `[default]
+[profile production]
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn         = arn:aws:iam::12345:role/my-role
+source_profile   = production
+duration_seconds = 900
+mfa_serial       = arn:aws:iam::22222:mfa/jane
+`,
+			run: func(filename string) error {
+				return generateAWSCLIProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, AWSCLICmd{
+					ConfigPath:       filename,
+					SourceProfile:    "default",
+					KeepCustomConfig: true,
+					MFASerial:        "arn:aws:iam::11111:mfa/jane",
+					DurationSeconds:  3600,
+				}, generation{order: util.OrderAlphabetical, profileKeys: util.ProfileKeyRules{
+					{Keys: map[string]string{
+						"duration_seconds": "900",
+						"mfa_serial":       "arn:aws:iam::22222:mfa/jane",
+						"source_profile":   "production",
+					}},
+				}})
+			},
+		},
+		{
+			describe:       "aws-cli",
+			it:             "uses a credential source instead of a source profile",
+			originalConfig: ``,
+			expectedConfig: `# generated by aws-cfg-generator
+[profile my-account]
+role_arn          = arn:aws:iam::12345:role/my-role
+credential_source = Ec2InstanceMetadata
+region            = eu-central-1
+`,
+			run: func(filename string) error {
+				return generateAWSCLIProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, AWSCLICmd{
+					ConfigPath:       filename,
+					SourceProfile:    "default",
+					CredentialSource: "Ec2InstanceMetadata",
+					Region:           "eu-central-1",
+					KeepCustomConfig: true,
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -250,12 +568,97 @@ aws_account_id = 12345
 role_name      = my-role
 color          = ffffff
 `,
-			run: func(filename string) {
-				generateSwitchRolesProfile(accountMap, roleArns, SwitchRolesCmd{
+			run: func(filename string) error {
+				return generateSwitchRolesProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, SwitchRolesCmd{
 					OutputFile:           filename,
 					UseRoleNameInProfile: false,
 					Color:                "ffffff",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe:       "switch-roles",
+			it:             "colors profiles by the stages of a custom stage model",
+			originalConfig: ``,
+			expectedConfig: `[payments-dev]
+aws_account_id = 12345
+role_name      = my-role
+color          = ffffff
+
+[payments-sandbox]
+aws_account_id = 67890
+role_name      = my-role
+color          = 123456
+`,
+			run: func(filename string) error {
+				stages, err := util.NewStageModel([]util.Stage{{Name: "sandbox", Color: "123456"}, {Name: "dev"}}, nil)
+				if err != nil {
+					return err
+				}
+
+				return generateSwitchRolesProfile(&util.Inventory{
+					Accounts: map[string]string{"12345": "payments-dev", "67890": "payments-sandbox"},
+					RoleArns: []string{"arn:aws:iam::12345:role/my-role", "arn:aws:iam::67890:role/my-role"},
+				}, SwitchRolesCmd{
+					OutputFile: filename,
+					Color:      "ffffff",
+					DevColor:   "00d619",
+				}, generation{order: util.OrderAlphabetical, stages: stages})
+			},
+		},
+		{
+			describe:       "switch-roles",
+			it:             "colors profiles of the default stages with the stage color flags",
+			originalConfig: ``,
+			expectedConfig: `[payments-global]
+aws_account_id = 67890
+role_name      = my-role
+color          = ff0000
+
+[payments-poc]
+aws_account_id = 12345
+role_name      = my-role
+color          = 00d619
+`,
+			run: func(filename string) error {
+				return generateSwitchRolesProfile(&util.Inventory{
+					Accounts: map[string]string{"12345": "payments-poc", "67890": "payments-global"},
+					RoleArns: []string{"arn:aws:iam::12345:role/my-role", "arn:aws:iam::67890:role/my-role"},
+				}, SwitchRolesCmd{
+					OutputFile: filename,
+					Color:      "ffffff",
+					DevColor:   "00d619",
+					IntColor:   "ffea00",
+					PrdColor:   "ff0000",
+				}, generation{order: util.OrderAlphabetical})
+			},
+		},
+		{
+			describe:       "switch-roles",
+			it:             "prefers aliases to the organization",
+			originalConfig: ``,
+			expectedConfig: `[payments]
+aws_account_id = 12345
+role_name      = my-role
+color          = ff0000
+
+[partner]
+aws_account_id = 67890
+role_name      = my-role
+color          = 0000ff
+`,
+			run: func(filename string) error {
+				return generateSwitchRolesProfile(&util.Inventory{
+					Accounts: accountMap,
+					RoleArns: []string{roleArns[0], "arn:aws:iam::67890:role/my-role"},
+				}, SwitchRolesCmd{
+					OutputFile: filename,
+					Color:      "ffffff",
+					PrdColor:   "ff0000",
+				}, generation{aliases: util.Aliases{
+					"12345": {Name: "payments", Stage: "prd"},
+					"67890": {Name: "partner", Color: "0000ff"},
+				}})
 			},
 		},
 		{
@@ -267,12 +670,12 @@ aws_account_id = 12345
 role_name      = my-role
 color          = ffffff
 `,
-			run: func(filename string) {
-				generateSwitchRolesProfile(accountMap, roleArns, SwitchRolesCmd{
+			run: func(filename string) error {
+				return generateSwitchRolesProfile(&util.Inventory{Accounts: accountMap, RoleArns: roleArns}, SwitchRolesCmd{
 					OutputFile:           filename,
 					UseRoleNameInProfile: true,
 					Color:                "ffffff",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 		{
@@ -284,12 +687,12 @@ aws_account_id = 67890
 role_name      = my-role
 color          = ffffff
 `,
-			run: func(filename string) {
-				generateSwitchRolesProfile(accountMap, []string{"arn:aws:iam::67890:role/my-role"}, SwitchRolesCmd{
+			run: func(filename string) error {
+				return generateSwitchRolesProfile(&util.Inventory{Accounts: accountMap, RoleArns: []string{"arn:aws:iam::67890:role/my-role"}}, SwitchRolesCmd{
 					OutputFile:           filename,
 					UseRoleNameInProfile: false,
 					Color:                "ffffff",
-				}, true)
+				}, generation{order: util.OrderAlphabetical})
 			},
 		},
 	}
@@ -304,7 +707,9 @@ color          = ffffff
 				}
 			}()
 
-			testCase.run(filename)
+			if err := testCase.run(filename); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
 
 			actualConfig := getFile(filename)
 
@@ -320,6 +725,456 @@ Got
 	}
 }
 
+func TestVaultKeepsHandWrittenProfiles(t *testing.T) {
+	filename := setup(`[default]
+
+# my own profile
+[profile my-account]
+output   = json
+role_arn = arn:aws:iam::12345:role/old-role
+`)
+	defer os.Remove(filename)
+
+	inventory := &util.Inventory{
+		Accounts: map[string]string{"12345": "my-account", "67890": "other-account"},
+		RoleArns: []string{"arn:aws:iam::12345:role/my-role", "arn:aws:iam::67890:role/my-role"},
+	}
+
+	expectedConfig := `[default]
+
+# my own profile
+[profile my-account]
+output          = json
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+
+# generated by aws-cfg-generator
+[profile other-account]
+role_arn        = arn:aws:iam::67890:role/my-role
+source_profile  = default
+include_profile = default
+`
+
+	// the second run must neither prune the custom keys nor claim the hand-written profile
+	for run := 1; run <= 2; run++ {
+		err := generateVaultProfile(inventory, VaultCmd{
+			VaultConfigPath:  filename,
+			SourceProfile:    "default",
+			KeepCustomConfig: true,
+		}, generation{order: util.OrderAlphabetical})
+		if err != nil {
+			t.Fatalf("unexpected error in run %d: %s", run, err)
+		}
+
+		if actualConfig := getFile(filename); actualConfig != expectedConfig {
+			t.Errorf("run %d: Expected\n%s\nGot\n%s", run, expectedConfig, actualConfig)
+		}
+	}
+}
+
+func TestVaultKeepsSourceProfileKeyOrder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config")
+	source := `[default]
+region     = eu-central-1
+output     = json
+mfa_serial = arn:aws:iam::11111:mfa/jane
+cli_pager  =
+
+[profile discarded]
+region = us-east-1
+`
+	if err := os.WriteFile(filename, []byte(source), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := &util.Inventory{
+		Accounts: map[string]string{"12345": "my-account"},
+		RoleArns: []string{"arn:aws:iam::12345:role/my-role"},
+	}
+	vault := VaultCmd{VaultConfigPath: filename, SourceProfile: "default", KeepCustomConfig: false}
+	vault.Backups = 2
+
+	expectedConfig := `[default]
+region     = eu-central-1
+output     = json
+mfa_serial = arn:aws:iam::11111:mfa/jane
+cli_pager  =
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+`
+
+	for run := 1; run <= 2; run++ {
+		if err := generateVaultProfile(inventory, vault, generation{}); err != nil {
+			t.Fatalf("unexpected error in run %d: %s", run, err)
+		}
+
+		if actualConfig := getFile(filename); actualConfig != expectedConfig {
+			t.Errorf("run %d: Expected\n%s\nGot\n%s", run, expectedConfig, actualConfig)
+		}
+	}
+
+	// only the first run changed the config
+	backups, err := generator.ListBackups(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(backups) != 1 {
+		t.Errorf("expected 1 backup, got %v", backups)
+	}
+}
+
+func TestVaultMissingSourceProfile(t *testing.T) {
+	filename := setup(`[profile other]`)
+	defer os.Remove(filename)
+
+	err := generateVaultProfile(&util.Inventory{Accounts: map[string]string{}, RoleArns: []string{}}, VaultCmd{
+		VaultConfigPath: filename,
+		SourceProfile:   `default`,
+	}, generation{order: util.OrderAlphabetical})
+
+	if kind := util.ErrorKindOf(err); kind != util.KindConfig {
+		t.Errorf("expected a config error, got %v (%s)", err, kind)
+	}
+
+	if code := util.ExitCode(err); code != util.KindConfig.ExitCode() {
+		t.Errorf("expected exit code %d, got %d", util.KindConfig.ExitCode(), code)
+	}
+}
+
+func TestVaultCredentialSource(t *testing.T) {
+	filename := setup(`[default]`)
+	defer os.Remove(filename)
+
+	err := generateVaultProfile(&util.Inventory{
+		Accounts: map[string]string{"12345": "my-account"},
+		RoleArns: []string{"arn:aws:iam::12345:role/my-role"},
+	}, VaultCmd{
+		VaultConfigPath: filename,
+		SourceProfile:   `default`,
+	}, generation{profileKeys: util.ProfileKeyRules{{Keys: map[string]string{"credential_source": "EcsContainer"}}}})
+
+	if kind := util.ErrorKindOf(err); kind != util.KindConfig {
+		t.Errorf("expected a config error, got %v (%s)", err, kind)
+	}
+}
+
+func TestVaultDryRun(t *testing.T) {
+	filename := setup(`[default]`)
+	defer os.Remove(filename)
+
+	summaryFile := filepath.Join(t.TempDir(), "summary.json")
+
+	var diff bytes.Buffer
+
+	err := generateVaultProfile(&util.Inventory{
+		Accounts: map[string]string{"12345": "my-account"},
+		RoleArns: []string{"arn:aws:iam::12345:role/my-role"},
+	}, VaultCmd{
+		VaultConfigPath: filename,
+		SourceProfile:   `default`,
+		outputFlags:     outputFlags{DryRun: true, ChangeSummary: summaryFile, diffOutput: &diff},
+	}, generation{order: util.OrderAlphabetical})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actualConfig := getFile(filename); actualConfig != `[default]` {
+		t.Errorf("expected the config to be unchanged, got\n%s", actualConfig)
+	}
+
+	expectedDiff := fmt.Sprintf(`--- %[1]s
++++ %[1]s
+@@ -1,1 +1,7 @@
+ [default]
++
++# generated by aws-cfg-generator
++[profile my-account]
++role_arn        = arn:aws:iam::12345:role/my-role
++source_profile  = default
++include_profile = default
+`, filename)
+	if diff.String() != expectedDiff {
+		t.Errorf("Expected diff\n%s\nGot\n%s", expectedDiff, diff.String())
+	}
+
+	var summary struct {
+		DryRun bool     `json:"dry_run"`
+		Added  []string `json:"added"`
+	}
+
+	if err := json.Unmarshal([]byte(getFile(summaryFile)), &summary); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !summary.DryRun || !reflect.DeepEqual(summary.Added, []string{"profile my-account"}) {
+		t.Errorf("unexpected change summary %+v", summary)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(filename, []byte("[default]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := &util.Inventory{
+		Accounts: map[string]string{"12345": "my-account"},
+		RoleArns: []string{"arn:aws:iam::12345:role/my-role"},
+	}
+	vault := VaultCmd{VaultConfigPath: filename, SourceProfile: "default", KeepCustomConfig: true}
+	vault.Backups = 2
+
+	// only changes are backed up, the second run doesn't change anything
+	for i := 0; i < 2; i++ {
+		if err := generateVaultProfile(inventory, vault, generation{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	generated := getFile(filename)
+
+	for i := 0; i < 2; i++ {
+		clean := CleanCmd{VaultConfigPath: filename, backupFlags: backupFlags{Backups: 2}}
+		if err := clean.Run(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := generateVaultProfile(inventory, vault, generation{}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	backups, err := generator.ListBackups(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+
+	// the newest backup is the config before it was generated the last time
+	if content := getFile(backups[0].Path); content != "[default]\n" {
+		t.Errorf("unexpected content of the newest backup\n%s", content)
+	}
+
+	restore := RestoreCmd{VaultConfigPath: filename, Backup: backups[0].Name(), backupFlags: backupFlags{Backups: 2}}
+	if err := restore.Run(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if content := getFile(filename); content != "[default]\n" {
+		t.Errorf("expected the backup to be restored, got\n%s", content)
+	}
+
+	// restoring backed up the generated config, so it can be undone
+	restore.Backup = "latest"
+	if err := restore.Run(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if content := getFile(filename); content != generated {
+		t.Errorf("expected the generated config to be restored, got\n%s", content)
+	}
+
+	restore.Backup = "missing"
+	if err := restore.Run(); util.ErrorKindOf(err) != util.KindConfig {
+		t.Errorf("expected a config error, got %v", err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	filename := setup(`[default]
+
+# generated by aws-cfg-generator
+# granted by group admins, policy admin-access
+[profile my-account]
+role_arn = arn:aws:iam::12345:role/my-role
+
+# my own profile
+[profile hand-written]
+role_arn = arn:aws:iam::67890:role/my-role
+`)
+	defer os.Remove(filename)
+
+	cmd := CleanCmd{VaultConfigPath: filename}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedConfig := `[default]
+
+# my own profile
+[profile hand-written]
+role_arn = arn:aws:iam::67890:role/my-role
+`
+	if actualConfig := getFile(filename); actualConfig != expectedConfig {
+		t.Errorf("Expected\n%s\nGot\n%s", expectedConfig, actualConfig)
+	}
+}
+
+func TestVaultCreate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ".aws", "config")
+	inventory := &util.Inventory{
+		Accounts: map[string]string{"12345": "my-account"},
+		RoleArns: []string{"arn:aws:iam::12345:role/my-role"},
+	}
+
+	vault := VaultCmd{VaultConfigPath: filename, SourceProfile: "default"}
+	if err := generateVaultProfile(inventory, vault, generation{}); util.ErrorKindOf(err) != util.KindIO {
+		t.Errorf("expected an io error without --create, got %v", err)
+	}
+
+	vault.Create = true
+	if err := generateVaultProfile(inventory, vault, generation{}); util.ErrorKindOf(err) != util.KindConfig {
+		t.Errorf("expected a config error without a source profile, got %v", err)
+	}
+
+	vault.sourceProfileKeys = func() (map[string]string, error) {
+		return map[string]string{"region": "eu-central-1", "mfa_serial": "arn:aws:iam::11111:mfa/jane"}, nil
+	}
+	if err := generateVaultProfile(inventory, vault, generation{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedConfig := `[default]
+mfa_serial = arn:aws:iam::11111:mfa/jane
+region     = eu-central-1
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn        = arn:aws:iam::12345:role/my-role
+source_profile  = default
+include_profile = default
+`
+	// the keys of a created source profile are sorted
+	if actualConfig := getFile(filename); actualConfig != expectedConfig {
+		t.Errorf("Expected\n%s\nGot\n%s", expectedConfig, actualConfig)
+	}
+
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the config to be only readable by its owner, got %v, %v", info, err)
+	}
+
+	// once it exists, its keys keep the order of the file
+	reordered := strings.Replace(expectedConfig, `mfa_serial = arn:aws:iam::11111:mfa/jane
+region     = eu-central-1`, `region     = eu-central-1
+mfa_serial = arn:aws:iam::11111:mfa/jane`, 1)
+	if err := os.WriteFile(filename, []byte(reordered), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := generateVaultProfile(inventory, vault, generation{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if actualConfig := getFile(filename); actualConfig != reordered {
+		t.Errorf("Expected\n%s\nGot\n%s", reordered, actualConfig)
+	}
+}
+
+func TestAWSCLIValidate(t *testing.T) {
+	for _, cmd := range []AWSCLICmd{{DurationSeconds: 60}, {CredentialSource: "Ec2"}, {MFASerial: "arn:aws:iam::11111:mfa/jane\n"}} {
+		if err := cmd.validate(); util.ErrorKindOf(err) != util.KindConfig {
+			t.Errorf("expected a config error for %+v, got %v", cmd, err)
+		}
+	}
+
+	if err := (AWSCLICmd{DurationSeconds: 3600, RoleSessionName: "jane"}).validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRoleSessionName(t *testing.T) {
+	filename := setup(`[default]`)
+	defer os.Remove(filename)
+
+	sessionNameTemplate, err := util.ParseSessionNameTemplate("aws-cfg-{{.UserName}}@{{.Email}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cli := CLI{}
+	inventory := &util.Inventory{
+		Accounts:  map[string]string{"12345": "my-account"},
+		RoleArns:  []string{"arn:aws:iam::12345:role/my-role"},
+		CallerArn: "arn:aws:iam::11111:user/Jane Doe",
+	}
+	gen := generation{sessionNameTemplate: sessionNameTemplate}
+
+	gen.roleSessionName, err = cli.roleSessionName(context.Background(), "inventory.json", inventory, gen)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := generateVaultProfile(inventory, VaultCmd{VaultConfigPath: filename, SourceProfile: "default"}, gen); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedConfig := `[default]
+
+# generated by aws-cfg-generator
+[profile my-account]
+role_arn          = arn:aws:iam::12345:role/my-role
+source_profile    = default
+include_profile   = default
+role_session_name = aws-cfg-Jane-Doe@
+`
+	if actualConfig := getFile(filename); actualConfig != expectedConfig {
+		t.Errorf("Expected\n%s\nGot\n%s", expectedConfig, actualConfig)
+	}
+
+	inventory.CallerArn = ""
+	if _, err := cli.roleSessionName(context.Background(), "inventory.json", inventory, gen); util.ErrorKindOf(err) != util.KindConfig {
+		t.Errorf("expected a config error without a caller, got %v", err)
+	}
+}
+
+func TestAWSContextIsBuiltOnce(t *testing.T) {
+	// a second context would start a second recorder, overwriting the calls recorded by the first one
+	cli := CLI{APIRegion: "eu-central-1", Record: filepath.Join(t.TempDir(), "calls.json")}
+
+	first, err := cli.awsContext()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	second, err := cli.awsContext()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if first != second {
+		t.Errorf("expected the AWS context to be reused")
+	}
+}
+
+func TestDiscoverFromInventory(t *testing.T) {
+	filename := setup(`{
+  "version": 1,
+  "accounts": {"12345": "my-account"},
+  "role_arns": ["arn:aws:iam::12345:role/my-role"]
+}`)
+	defer os.Remove(filename)
+
+	cli := CLI{Role: "my-role"}
+
+	inventory, err := cli.discover(context.Background(), filename, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(inventory.RoleArns) != 1 || inventory.Accounts["12345"] != "my-account" {
+		t.Errorf("discover() = %v", inventory)
+	}
+}
+
 func getFile(filename string) string {
 	content, err := os.ReadFile(filename)
 	if err != nil {
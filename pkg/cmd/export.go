@@ -0,0 +1,42 @@
+package cmd
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// nolint:govet // we need the bare `required` tag here
+type ExportCmd struct {
+	OutputFile string `help:"Where to save the inventory snapshot" required`
+}
+
+func (ec *ExportCmd) Run(cli *CLI, ctx context.Context) error {
+	// include organizational units and tags, so that the snapshot works with any profile name template
+	inventory, err := cli.discover(ctx, "", true)
+	if err != nil {
+		return err
+	}
+
+	err = inventory.Save(ec.OutputFile)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("file-path", ec.OutputFile).Msgf("Exported %d roles and %d accounts", len(inventory.RoleArns), len(inventory.Accounts))
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package cmd
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+)
+
+// nolint:govet // we need the bare `required` tag here
+type RestoreCmd struct {
+	VaultConfigPath string `help:"The aws-vault config to restore" required`
+	Backup          string `help:"The backup to restore, given by its file name, its timestamp or latest. Lists the backups if not set"`
+
+	outputFlags `embed:""`
+	backupFlags `embed:""`
+}
+
+func (rc *RestoreCmd) Run() error {
+	if rc.Backup == "" {
+		return listBackups(rc.VaultConfigPath)
+	}
+
+	output := rc.output()
+	output.Backups = rc.Backups
+
+	backup, err := generator.RestoreBackup(rc.VaultConfigPath, rc.Backup, output)
+	if err != nil {
+		return err
+	}
+
+	if !rc.DryRun {
+		log.Info().Str("file-path", rc.VaultConfigPath).Str("backup", backup.Path).Msg("Restored config")
+	}
+
+	return nil
+}
+
+func listBackups(configPath string) error {
+	backups, err := generator.ListBackups(configPath)
+	if err != nil {
+		return err
+	}
+
+	if len(backups) == 0 {
+		log.Info().Str("file-path", configPath).Msg("No backups found")
+		return nil
+	}
+
+	for _, backup := range backups {
+		if _, err := fmt.Fprintf(os.Stdout, "%s  %s\n", backup.Time.Format("2006-01-02 15:04:05 MST"), backup.Name()); err != nil {
+			return util.IOError(err, "could not list backups")
+		}
+	}
+
+	return nil
+}
@@ -14,69 +14,59 @@ package cmd
 */
 
 import (
-	"strings"
+	"context"
 
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
 	"github.com/moia-oss/aws-cfg-generator/pkg/util"
-	"github.com/rs/zerolog/log"
-	"gopkg.in/ini.v1"
 )
 
 // nolint:govet // we need the bare `required` tag here
 type SwitchRolesCmd struct {
-	Color                string `help:"The hexcode color that should be set for each profile which name doesn't end in 'prd' or 'global'" default:"00ff7f"`
-	DevColor             string `help:"The hexcode color that should be set for each profile which name ends in 'dev' or 'poc'" default:"00d619"`
-	IntColor             string `help:"The hexcode color that should be set for each profile which name ends in 'int' or 'stg'" default:"ffea00"`
-	PrdColor             string `help:"The hexcode color that should be set for each profile which name ends in 'prd' or 'global'" default:"ff0000"`
+	Color                string `help:"The hexcode color that should be set for each profile without a stage" default:"00ff7f"`
+	DevColor             string `help:"The hexcode color that should be set for each profile of the stages 'dev' and 'poc'" default:"00d619"`
+	IntColor             string `help:"The hexcode color that should be set for each profile of the stages 'int' and 'stg'" default:"ffea00"`
+	PrdColor             string `help:"The hexcode color that should be set for each profile of the stages 'prd' and 'global'" default:"ff0000"`
 	OutputFile           string `help:"Where to save the config." required`
 	UseRoleNameInProfile bool   `help:"Append the role name to the profile name" default:false`
-}
-
-func (swc *SwitchRolesCmd) Run(cli *CLI) error {
-	roleArns, accountMap := util.GetAWSContext().GetRolesAndAccounts(cli.Role)
-	generateSwitchRolesProfile(accountMap, roleArns, cli.SwitchRoles, cli.Ordered)
+	Inventory            string `help:"Generate from an inventory snapshot written by the export command instead of calling AWS" type:"existingfile"`
 
-	return nil
+	outputFlags `embed:""`
 }
 
-func envSpecificColor(profileName string, cmdOptions SwitchRolesCmd) string {
-	lowerKeyProfileName := strings.ToLower(profileName)
-
-	if strings.HasSuffix(lowerKeyProfileName, "dev") || strings.HasSuffix(lowerKeyProfileName, "poc") {
-		return cmdOptions.DevColor
-	}
-
-	if strings.HasSuffix(lowerKeyProfileName, "int") || strings.HasSuffix(lowerKeyProfileName, "stg") {
-		return cmdOptions.IntColor
+func (swc *SwitchRolesCmd) Run(cli *CLI, ctx context.Context) error {
+	gen, err := cli.generation()
+	if err != nil {
+		return err
 	}
 
-	if strings.HasSuffix(lowerKeyProfileName, "prd") || strings.HasSuffix(lowerKeyProfileName, "global") {
-		return cmdOptions.PrdColor
+	inventory, err := cli.discover(ctx, cli.SwitchRoles.Inventory, gen.needsAccountDetails())
+	if err != nil {
+		return err
 	}
 
-	return cmdOptions.Color
+	return generateSwitchRolesProfile(inventory, cli.SwitchRoles, gen)
 }
 
-func generateSwitchRolesProfile(accountMap map[string]string, roleArns []string, cmdOptions SwitchRolesCmd, ordered bool) {
-	config := ini.Empty()
-
-	profiles := util.GetProfiles("", accountMap, roleArns, cmdOptions.UseRoleNameInProfile)
-
-	if ordered {
-		profiles = util.OrderProfiles(profiles)
-	}
-
-	for _, profile := range profiles {
-		profileSection := config.Section(profile.ProfileName)
-
-		setKey := util.GetKeySetter(profileSection)
-
-		setKey("aws_account_id", profile.AccountID)
-		setKey("role_name", profile.RoleName)
-		setKey("color", envSpecificColor(profile.ProfileName, cmdOptions))
+func (swc SwitchRolesCmd) options(gen generation) generator.SwitchRolesOptions {
+	return generator.SwitchRolesOptions{
+		Color:                swc.Color,
+		DevColor:             swc.DevColor,
+		IntColor:             swc.IntColor,
+		PrdColor:             swc.PrdColor,
+		OutputFile:           swc.OutputFile,
+		UseRoleNameInProfile: swc.UseRoleNameInProfile,
+		Order:                gen.order,
+		Usage:                gen.usage,
+		Collisions:           gen.collisions,
+		NameTemplate:         gen.nameTemplate,
+		NameRules:            gen.nameRules,
+		Aliases:              gen.aliases,
+		Filters:              gen.filters,
+		Stages:               gen.stages,
+		Output:               swc.output(),
 	}
+}
 
-	err := config.SaveTo(cmdOptions.OutputFile)
-	if err != nil {
-		log.Panic().Err(err).Str("file-path", cmdOptions.OutputFile).Msg("could not save file")
-	}
+func generateSwitchRolesProfile(inventory *util.Inventory, cmdOptions SwitchRolesCmd, gen generation) error {
+	return generator.GenerateSwitchRoles(inventory, cmdOptions.options(gen))
 }
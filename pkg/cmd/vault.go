@@ -14,11 +14,12 @@ package cmd
 */
 
 import (
-	"fmt"
+	"context"
 
-	"github.com/moia-oss/aws-cfg-generator/pkg/util"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/ini.v1"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/generator"
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
 )
 
 // nolint:govet // we need the bare `required` tag here
@@ -28,68 +29,105 @@ type VaultCmd struct {
 	VaultConfigPath      string `help:"Where to load/save the config" required`
 	KeepCustomConfig     bool   `help:"Retains any custom profiles or settings. Set to false to remove everything except the source profile and generated config" default:true`
 	UseRoleNameInProfile bool   `help:"Append the role name to the profile name" default:false`
-}
+	Inventory            string `help:"Generate from an inventory snapshot written by the export command instead of calling AWS" type:"existingfile"`
+	Create               bool   `help:"Create the config if it doesn't exist" default:"false"`
+	CreateSourceProfile  bool   `help:"Create the source profile if it is missing, with the serial number of your MFA device and the region" default:"false"`
 
-func (vc *VaultCmd) Run(cli *CLI) error {
-	roleArns, accountMap := util.GetAWSContext().GetRolesAndAccounts(cli.Role)
-	generateVaultProfile(accountMap, roleArns, cli.Vault, cli.Ordered)
+	outputFlags `embed:""`
+	backupFlags `embed:""`
 
-	return nil
+	// looks up the keys of a source profile created by --create-source-profile
+	sourceProfileKeys func() (map[string]string, error)
 }
 
-func generateVaultProfile(accountMap map[string]string, roleArns []string, cmdOptions VaultCmd, ordered bool) {
-	config, err := ini.Load(cmdOptions.VaultConfigPath)
+func (vc *VaultCmd) Run(cli *CLI, ctx context.Context) error {
+	gen, err := cli.generation()
 	if err != nil {
-		log.Panic().Err(err).Str("file-path", cmdOptions.VaultConfigPath).Msg("could not load config")
+		return err
 	}
 
-	sourceProfileSectionName := cmdOptions.SourceProfile
-
-	// the profile can either be [default] or [profile foo]
-	if sourceProfileSectionName != "default" {
-		sourceProfileSectionName = fmt.Sprint("profile ", sourceProfileSectionName)
+	inventory, err := cli.discover(ctx, cli.Vault.Inventory, gen.needsAccountDetails())
+	if err != nil {
+		return err
 	}
 
-	// make sure the source section exists
-	_, err = config.GetSection(sourceProfileSectionName)
+	gen.roleSessionName, err = cli.roleSessionName(ctx, vc.Inventory, inventory, gen)
 	if err != nil {
-		log.Panic().Err(err).Str("section", sourceProfileSectionName).Msg("source profile not found")
+		return err
 	}
 
-	// only copy the source profile and generated profiles, discard the rest of the config
-	if !cmdOptions.KeepCustomConfig {
-		newConfig := ini.Empty()
+	if vc.CreateSourceProfile {
+		cli.Vault.sourceProfileKeys = func() (map[string]string, error) {
+			return vc.lookupSourceProfileKeys(ctx, cli)
+		}
+	}
 
-		setProfileKey := util.GetKeySetter(newConfig.Section(sourceProfileSectionName))
+	return generateVaultProfile(inventory, cli.Vault, gen)
+}
 
-		for key, value := range config.Section(sourceProfileSectionName).KeysHash() {
-			setProfileKey(key, value)
-		}
+// lookupSourceProfileKeys returns the region and the serial number of the caller's MFA device for a new source profile
+func (vc *VaultCmd) lookupSourceProfileKeys(ctx context.Context, cli *CLI) (map[string]string, error) {
+	keys := map[string]string{}
 
-		config = newConfig
+	if vc.Region != "" {
+		keys["region"] = vc.Region
 	}
-	profiles := util.GetProfiles("profile ", accountMap, roleArns, cmdOptions.UseRoleNameInProfile)
 
-	if ordered {
-		profiles = util.OrderProfiles(profiles)
+	if vc.Inventory != "" {
+		log.Warn().Msg("mfa_serial is only looked up when discovering from AWS, the source profile is created without it")
+		return keys, nil
 	}
 
-	for _, profile := range profiles {
-		profileSection := config.Section(profile.ProfileName)
+	// the context that discovered the roles, so that --record keeps all calls
+	awsContext, err := cli.awsContext()
+	if err != nil {
+		return nil, err
+	}
 
-		setKey := util.GetKeySetter(profileSection)
+	mfaSerial, err := awsContext.WithContext(ctx).GetMFASerial()
+	if err != nil {
+		return nil, err
+	}
 
-		setKey("role_arn", profile.RoleArn)
-		setKey("source_profile", cmdOptions.SourceProfile)
-		setKey("include_profile", cmdOptions.SourceProfile)
+	if mfaSerial != "" {
+		keys["mfa_serial"] = mfaSerial
+	} else {
+		log.Warn().Msg("you have no MFA device, the source profile is created without mfa_serial")
+	}
 
-		if cmdOptions.Region != "" {
-			setKey("region", cmdOptions.Region)
-		}
+	if _, ok := keys["region"]; !ok && awsContext.Region() != "" {
+		keys["region"] = awsContext.Region()
 	}
 
-	err = config.SaveTo(cmdOptions.VaultConfigPath)
-	if err != nil {
-		log.Panic().Err(err).Str("file-path", cmdOptions.VaultConfigPath).Msg("could not save config")
+	return keys, nil
+}
+
+func (vc VaultCmd) options(gen generation) generator.VaultOptions {
+	output := vc.output()
+	output.Backups = vc.Backups
+
+	return generator.VaultOptions{
+		SourceProfile:        vc.SourceProfile,
+		Region:               vc.Region,
+		RoleSessionName:      gen.roleSessionName,
+		ConfigPath:           vc.VaultConfigPath,
+		KeepCustomConfig:     vc.KeepCustomConfig,
+		UseRoleNameInProfile: vc.UseRoleNameInProfile,
+		Order:                gen.order,
+		Usage:                gen.usage,
+		Collisions:           gen.collisions,
+		NameTemplate:         gen.nameTemplate,
+		NameRules:            gen.nameRules,
+		Aliases:              gen.aliases,
+		Filters:              gen.filters,
+		Stages:               gen.stages,
+		ProfileKeys:          gen.profileKeys,
+		Output:               output,
+		Create:               vc.Create,
+		CreateSourceProfile:  vc.sourceProfileKeys,
 	}
 }
+
+func generateVaultProfile(inventory *util.Inventory, cmdOptions VaultCmd, gen generation) error {
+	return generator.GenerateVault(inventory, cmdOptions.options(gen))
+}
@@ -0,0 +1,137 @@
+package generator
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+	"gopkg.in/ini.v1"
+)
+
+type AWSCLIOptions struct {
+	// Where the config is loaded from and saved to, and how the profiles are named, filtered, ordered and merged, the
+	// same as for aws-vault
+	VaultOptions
+	// If set, the roles are assumed with the credentials of the environment or of the EC2 instance or ECS container,
+	// e.g. Ec2InstanceMetadata, instead of those of the source profile
+	CredentialSource string
+	// The serial number of the MFA device to assume the roles with, defaults to the mfa_serial of the source profile
+	MFASerial string
+	// If set, how long the sessions of the assumed roles last
+	DurationSeconds int
+}
+
+// GenerateAWSCLI adds a profile for every role of the inventory to the AWS CLI config at opts.ConfigPath. Unlike the
+// aws-vault config, the profiles only have keys that the AWS CLI and SDKs understand.
+func GenerateAWSCLI(inventory *util.Inventory, opts AWSCLIOptions) error {
+	vaultOptions := opts.VaultOptions
+	if opts.CredentialSource != "" {
+		// there is no source profile to keep or create
+		vaultOptions.SourceProfile = ""
+		vaultOptions.CreateSourceProfile = nil
+	}
+
+	return generateConfig(inventory, vaultOptions, util.TargetAWSCLI,
+		func(config *ini.File, section *ini.Section, profile util.Profile, keys map[string]string) error {
+			return setAWSCLIProfileKeys(config, section, profile, keys, opts)
+		})
+}
+
+// setAWSCLIProfileKeys sets the keys of a generated profile, followed by the keys of the matching rules in
+// alphabetical order. The AWS CLI doesn't read the mfa_serial of the source profile when assuming a role, so it is
+// copied unless it is set otherwise.
+func setAWSCLIProfileKeys(config *ini.File, profileSection *ini.Section, profile util.Profile,
+	keys map[string]string, opts AWSCLIOptions) error {
+	setKey := util.GetKeySetter(profileSection)
+
+	// a profile has either a source profile or a credential source, and the keys of the rules take precedence
+	sourceProfile, credentialSource := opts.SourceProfile, opts.CredentialSource
+	if credentialSource != "" {
+		sourceProfile = ""
+	}
+
+	if value, ok := keys["source_profile"]; ok {
+		sourceProfile, credentialSource = value, ""
+	}
+
+	if value, ok := keys["credential_source"]; ok {
+		sourceProfile, credentialSource = "", value
+	}
+
+	if err := setKey("role_arn", profile.RoleArn); err != nil {
+		return err
+	}
+
+	if credentialSource != "" {
+		if err := setKey("credential_source", credentialSource); err != nil {
+			return err
+		}
+	} else if err := setKey("source_profile", sourceProfile); err != nil {
+		return err
+	}
+
+	defaults := map[string]string{
+		"role_session_name": opts.RoleSessionName,
+		"mfa_serial":        opts.MFASerial,
+		"region":            opts.Region,
+	}
+
+	if opts.MFASerial == "" && sourceProfile != "" {
+		defaults["mfa_serial"] = sourceProfileKey(config, sourceProfile, "mfa_serial")
+	}
+
+	if opts.DurationSeconds != 0 {
+		defaults["duration_seconds"] = strconv.Itoa(opts.DurationSeconds)
+	}
+
+	// the keys of the rules take precedence
+	for _, key := range []string{"role_session_name", "mfa_serial", "duration_seconds", "region"} {
+		if _, ok := keys[key]; ok || defaults[key] == "" {
+			continue
+		}
+
+		if err := setKey(key, defaults[key]); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		if key != "source_profile" && key != "credential_source" {
+			names = append(names, key)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, key := range names {
+		if err := setKey(key, keys[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sourceProfileKey returns the value of a key of a source profile, or an empty string if it isn't set
+func sourceProfileKey(config *ini.File, sourceProfile, key string) string {
+	section, err := config.GetSection(sourceProfileSectionName(sourceProfile))
+	if err != nil || !section.HasKey(key) {
+		return ""
+	}
+
+	return section.Key(key).String()
+}
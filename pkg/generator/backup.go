@@ -0,0 +1,206 @@
+package generator
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+)
+
+// the timestamp in the file names of backups, which sort in chronological order
+const backupTimeFormat = "20060102T150405Z"
+
+// Backup is a copy of a config taken before it was overwritten
+type Backup struct {
+	Path string
+	Time time.Time
+	// tells apart backups taken within the same second
+	seq int
+}
+
+// Name is how the backup is referred to, e.g. by RestoreBackup
+func (b Backup) Name() string {
+	return filepath.Base(b.Path)
+}
+
+func backupPath(configPath string, t time.Time, seq int) string {
+	suffix := t.UTC().Format(backupTimeFormat)
+	if seq > 0 {
+		suffix += fmt.Sprintf("-%d", seq)
+	}
+
+	return fmt.Sprintf("%s.%s.bak", configPath, suffix)
+}
+
+// ListBackups returns the backups of the config at configPath, newest first
+func ListBackups(configPath string) ([]Backup, error) {
+	dir := filepath.Dir(configPath)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, util.IOError(err, "could not list backups in %s", dir)
+	}
+
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(configPath)) + `\.(\d{8}T\d{6}Z)(?:-(\d+))?\.bak$`)
+
+	var backups []Backup
+
+	for _, entry := range entries {
+		match := pattern.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+
+		t, err := time.Parse(backupTimeFormat, match[1])
+		if err != nil {
+			continue
+		}
+
+		seq, _ := strconv.Atoi(match[2])
+
+		backups = append(backups, Backup{Path: filepath.Join(dir, entry.Name()), Time: t, seq: seq})
+	}
+
+	slices.SortFunc(backups, func(a, b Backup) bool {
+		if !a.Time.Equal(b.Time) {
+			return a.Time.After(b.Time)
+		}
+
+		return a.seq > b.seq
+	})
+
+	return backups, nil
+}
+
+// backupConfig saves content, the current config at configPath, as a new backup and removes all but the newest keep
+// backups
+func backupConfig(configPath string, content []byte, keep int) error {
+	mode := fs.FileMode(0o600)
+	if info, err := os.Stat(configPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	backups, err := ListBackups(configPath)
+	if err != nil {
+		return err
+	}
+
+	// a freed up sequence number would sort before the backups of the same second
+	seq := 0
+
+	for _, backup := range backups {
+		if backup.Time.Equal(now) && backup.seq >= seq {
+			seq = backup.seq + 1
+		}
+	}
+
+	var path string
+
+	for ; ; seq++ {
+		path = backupPath(configPath, now, seq)
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+
+		if err != nil {
+			return util.IOError(err, "could not back up config %s", configPath)
+		}
+
+		_, err = file.Write(content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return util.IOError(err, "could not back up config %s", configPath)
+		}
+
+		break
+	}
+
+	log.Info().Str("file-path", path).Msg("Backed up config")
+
+	backups, err = ListBackups(configPath)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		oldest := backups[len(backups)-1]
+		backups = backups[:len(backups)-1]
+
+		if err := os.Remove(oldest.Path); err != nil {
+			return util.IOError(err, "could not remove backup %s", oldest.Path)
+		}
+
+		log.Debug().Str("file-path", oldest.Path).Msg("Removed old backup")
+	}
+
+	return nil
+}
+
+// RestoreBackup replaces the config at configPath with one of its backups, given by its name, its timestamp or
+// "latest". The replaced config is backed up as well, so that restoring can be undone.
+func RestoreBackup(configPath, name string, opts OutputOptions) (Backup, error) {
+	unlock, err := lockConfig(configPath, opts)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer unlock()
+
+	backups, err := ListBackups(configPath)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	var backup *Backup
+
+	for i := range backups {
+		if name == "latest" && i == 0 || backups[i].Name() == name || backups[i].Path == name ||
+			backups[i].Time.Format(backupTimeFormat) == name {
+			backup = &backups[i]
+			break
+		}
+	}
+
+	if backup == nil {
+		return Backup{}, util.ConfigError(nil, "no backup %q of %s, found %d backups", name, configPath, len(backups))
+	}
+
+	content, err := os.ReadFile(backup.Path)
+	if err != nil {
+		return Backup{}, util.IOError(err, "could not read backup %s", backup.Path)
+	}
+
+	return *backup, saveContent(configPath, content, opts)
+}
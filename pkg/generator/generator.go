@@ -0,0 +1,103 @@
+// Package generator discovers the roles an IAM user is allowed to assume and generates configs for AWS helper tools
+// from them. It is used by the aws-cfg-generator CLI but can be embedded in other tools as well.
+package generator
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/ini.v1"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+)
+
+type DiscoverOptions struct {
+	// If set, then a profile with this role will be generated for every account in the organization
+	Role string
+	// If set, discovery results are reused from and stored in this cache
+	Cache *util.Cache
+	// Also discover the organizational unit and tags of every account, e.g. for a profile name template using them
+	AccountDetails bool
+}
+
+// Discover finds all roles the caller of awsContext may assume and the names of the organization's accounts.
+// All API calls are cancelled when ctx is done.
+func Discover(ctx context.Context, awsContext *util.AWSContext, opts DiscoverOptions) (*util.Inventory, error) {
+	awsContext = awsContext.WithContext(ctx)
+
+	if opts.AccountDetails {
+		awsContext = awsContext.WithAccountDetails()
+	}
+
+	if opts.Cache != nil {
+		return awsContext.GetCachedRolesAndAccounts(opts.Cache, opts.Role)
+	}
+
+	return awsContext.GetRolesAndAccounts(opts.Role)
+}
+
+// LoadInventory reads a snapshot previously written by util.Inventory.Save instead of calling AWS
+func LoadInventory(path string, opts DiscoverOptions) (*util.Inventory, error) {
+	inventory, err := util.ReadInventory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.AccountDetails && inventory.AccountDetails == nil {
+		log.Warn().Str("file-path", path).Msg("inventory has no organizational units and tags of accounts, export it again to include them")
+	}
+
+	inventory.AddOrgRole(opts.Role)
+
+	return inventory, nil
+}
+
+// generatedMarker is the comment line marking the sections written by the generator, so that reruns and the clean
+// command only touch their own sections
+const generatedMarker = "# generated by aws-cfg-generator"
+
+// isGenerated reports whether a section was written by the generator
+func isGenerated(section *ini.Section) bool {
+	for _, line := range strings.Split(section.Comment, "\n") {
+		if strings.TrimSpace(line) == generatedMarker {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generatedComment marks the section of a profile as generated, followed by its provenance
+func generatedComment(profile util.Profile) string {
+	if len(profile.Grants) == 0 {
+		return generatedMarker
+	}
+
+	return generatedMarker + "\n" + provenanceComment(profile)
+}
+
+// provenanceComment explains which policies granted the role of a profile, to be written above its section
+func provenanceComment(profile util.Profile) string {
+	lines := make([]string, 0, len(profile.Grants))
+
+	for _, grant := range profile.Grants {
+		lines = append(lines, fmt.Sprintf("# granted by %s", grant))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,161 @@
+package generator
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/ini.v1"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+)
+
+// OutputOptions controls how a generated config is written
+type OutputOptions struct {
+	// Print the changes as a unified diff to DiffOutput instead of writing the config
+	DryRun bool
+	// Where the diff of a dry run is printed, os.Stdout if nil
+	DiffOutput io.Writer
+	// Color the diff with ANSI escape codes
+	Color bool
+	// If set, a JSON summary of the changed sections and keys is written to this file, or to stdout for "-"
+	ChangeSummary string
+	// How many timestamped backups of the file are kept, the current file is backed up before it changes if set
+	Backups int
+}
+
+// how long to wait for another process writing the same config
+const configLockTimeout = 30 * time.Second
+
+// changeSummary is the machine-readable summary written to OutputOptions.ChangeSummary
+type changeSummary struct {
+	File   string `json:"file"`
+	DryRun bool   `json:"dry_run"`
+	*util.ConfigChanges
+}
+
+// saveConfig writes config to path, or prints how it would change the file at path for a dry run
+func saveConfig(config *ini.File, path string, opts OutputOptions) error {
+	current, exists, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	// sections that didn't change keep their formatting and comments
+	content, err := util.RenderPreserving(current, config)
+	if err != nil {
+		return err
+	}
+
+	return writeConfig(path, current, exists, content, opts)
+}
+
+// saveContent replaces the file at path with content, backing up the current file if it changes
+func saveContent(path string, content []byte, opts OutputOptions) error {
+	current, exists, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	return writeConfig(path, current, exists, content, opts)
+}
+
+func readConfig(path string) (content []byte, exists bool, err error) {
+	content, err = os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, util.IOError(err, "could not read config %s", path)
+	}
+
+	return content, err == nil, nil
+}
+
+// writeConfig replaces current, the config at path, with content, or prints how it would change for a dry run
+func writeConfig(path string, current []byte, exists bool, content []byte, opts OutputOptions) error {
+	if opts.ChangeSummary != "" {
+		if err := writeChangeSummary(path, current, content, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		out := opts.DiffOutput
+		if out == nil {
+			out = os.Stdout
+		}
+
+		diff := util.UnifiedDiff(path, path, current, content, opts.Color)
+		if diff == "" {
+			log.Info().Str("file-path", path).Msg("No changes")
+			return nil
+		}
+
+		if _, err := fmt.Fprint(out, diff); err != nil {
+			return util.IOError(err, "could not print diff")
+		}
+
+		return nil
+	}
+
+	if exists && opts.Backups > 0 && !bytes.Equal(current, content) {
+		if err := backupConfig(path, current, opts.Backups); err != nil {
+			return err
+		}
+	}
+
+	return util.WriteFileAtomic(path, content, 0o600)
+}
+
+// lockConfig takes the lock of the config at path until unlock is called, so that concurrent runs don't overwrite each
+// other's changes. Dry runs don't write and don't need it.
+func lockConfig(path string, opts OutputOptions) (unlock func(), err error) {
+	if opts.DryRun {
+		return func() {}, nil
+	}
+
+	return util.LockFile(path, configLockTimeout)
+}
+
+func writeChangeSummary(path string, current, content []byte, opts OutputOptions) error {
+	changes, err := util.CompareConfigs(current, content)
+	if err != nil {
+		return err
+	}
+
+	summary, err := json.MarshalIndent(changeSummary{File: path, DryRun: opts.DryRun, ConfigChanges: changes}, "", "  ")
+	if err != nil {
+		return util.IOError(err, "could not encode change summary")
+	}
+
+	summary = append(summary, '\n')
+
+	if opts.ChangeSummary == "-" {
+		_, err = os.Stdout.Write(summary)
+	} else {
+		err = os.WriteFile(opts.ChangeSummary, summary, 0o644)
+	}
+
+	if err != nil {
+		return util.IOError(err, "could not write change summary %s", opts.ChangeSummary)
+	}
+
+	return nil
+}
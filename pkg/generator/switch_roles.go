@@ -0,0 +1,127 @@
+package generator
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+	"gopkg.in/ini.v1"
+)
+
+type SwitchRolesOptions struct {
+	// The hexcode color for profiles without a stage or color of their own
+	Color string
+	// The hexcode color for profiles of the default stages 'dev' and 'poc' without a color of their own
+	DevColor string
+	// The hexcode color for profiles of the default stages 'int' and 'stg' without a color of their own
+	IntColor string
+	// The hexcode color for profiles of the default stages 'prd' and 'global' without a color of their own
+	PrdColor string
+	// Where to save the config
+	OutputFile string
+	// Append the role name to the profile name
+	UseRoleNameInProfile bool
+	// How profiles of different roles with the same name are told apart
+	Collisions util.CollisionStrategy
+	// If set, profile names are rendered from this template instead, and UseRoleNameInProfile is ignored
+	NameTemplate *util.ProfileNameTemplate
+	// If set, account and role names are normalized with these rules before they are used in profile names
+	NameRules *util.NameRules
+	// Account names, stages and colors taking precedence over what is known from the organization
+	Aliases util.Aliases
+	// If set, only roles passing these filters get a profile
+	Filters *util.Filters
+	// The stages accounts belong to and their colors, defaults to util.DefaultStageModelWithColors of DevColor, IntColor
+	// and PrdColor
+	Stages *util.StageModel
+	// How to order the profiles, they keep the order of the inventory if empty
+	Order util.OrderStrategy
+	// When roles were last used, for util.OrderRecent
+	Usage util.RoleUsage
+	// How the config is written, e.g. as a dry run
+	Output OutputOptions
+}
+
+func envSpecificColor(profile util.Profile, opts SwitchRolesOptions) string {
+	// set in the aliases or the stage model
+	if profile.Color != "" {
+		return profile.Color
+	}
+
+	return opts.Color
+}
+
+// GenerateSwitchRoles writes a config for aws-extend-switch-roles with a profile for every role of the inventory to
+// opts.OutputFile
+func GenerateSwitchRoles(inventory *util.Inventory, opts SwitchRolesOptions) error {
+	unlock, err := lockConfig(opts.OutputFile, opts.Output)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stages := opts.Stages
+	if stages == nil {
+		stages, err = util.DefaultStageModelWithColors(opts.DevColor, opts.IntColor, opts.PrdColor)
+		if err != nil {
+			return err
+		}
+	}
+
+	config := ini.Empty()
+
+	profiles, err := util.GetProfiles("", inventory, util.ProfileOptions{
+		UseRoleName:  opts.UseRoleNameInProfile,
+		Collisions:   opts.Collisions,
+		NameTemplate: opts.NameTemplate,
+		NameRules:    opts.NameRules,
+		Aliases:      opts.Aliases,
+		Filters:      opts.Filters,
+		Stages:       stages,
+		Target:       util.TargetSwitchRoles,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.Order != "" {
+		profiles, err = util.OrderProfilesBy(profiles, opts.Order, stages, opts.Usage)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, profile := range profiles {
+		if err := setSwitchRolesProfileKeys(config.Section(profile.ProfileName), profile, opts); err != nil {
+			return err
+		}
+	}
+
+	return saveConfig(config, opts.OutputFile, opts.Output)
+}
+
+func setSwitchRolesProfileKeys(profileSection *ini.Section, profile util.Profile, opts SwitchRolesOptions) error {
+	profileSection.Comment = provenanceComment(profile)
+
+	setKey := util.GetKeySetter(profileSection)
+
+	if err := setKey("aws_account_id", profile.AccountID); err != nil {
+		return err
+	}
+
+	if err := setKey("role_name", profile.RoleName); err != nil {
+		return err
+	}
+
+	return setKey("color", envSpecificColor(profile, opts))
+}
@@ -0,0 +1,367 @@
+package generator
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/moia-oss/aws-cfg-generator/pkg/util"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/ini.v1"
+)
+
+type VaultOptions struct {
+	// The profile that the credentials should come from, only the AWS CLI config may go without one
+	SourceProfile string
+	// Overrides the region configured with the source profile
+	Region string
+	// If set, the name of the sessions of the assumed roles, shown in CloudTrail
+	RoleSessionName string
+	// Where to load/save the config
+	ConfigPath string
+	// Retains any custom profiles or settings
+	KeepCustomConfig bool
+	// Append the role name to the profile name
+	UseRoleNameInProfile bool
+	// How profiles of different roles with the same name are told apart
+	Collisions util.CollisionStrategy
+	// If set, profile names are rendered from this template instead, and UseRoleNameInProfile is ignored
+	NameTemplate *util.ProfileNameTemplate
+	// If set, account and role names are normalized with these rules before they are used in profile names
+	NameRules *util.NameRules
+	// Account names, stages and colors taking precedence over what is known from the organization
+	Aliases util.Aliases
+	// If set, only roles passing these filters get a profile
+	Filters *util.Filters
+	// The stages accounts belong to, defaults to util.DefaultStageModel
+	Stages *util.StageModel
+	// How to order the profiles, they keep the order of the inventory if empty
+	Order util.OrderStrategy
+	// When roles were last used, for util.OrderRecent
+	Usage util.RoleUsage
+	// How the config is written, e.g. as a dry run
+	Output OutputOptions
+	// Additional keys of the profiles of matching roles, overriding source_profile and region
+	ProfileKeys util.ProfileKeyRules
+	// Create the config if it doesn't exist
+	Create bool
+	// If set, a missing source profile is created with the keys it returns, e.g. mfa_serial and region, instead of
+	// failing
+	CreateSourceProfile func() (map[string]string, error)
+}
+
+// profileKeySetter sets the keys of the generated profile of a role, keys are those of the matching profile key rules
+type profileKeySetter func(config *ini.File, section *ini.Section, profile util.Profile, keys map[string]string) error
+
+// GenerateVault adds a profile for every role of the inventory to the aws-vault config at opts.ConfigPath
+func GenerateVault(inventory *util.Inventory, opts VaultOptions) error {
+	return generateConfig(inventory, opts, util.TargetVault,
+		func(_ *ini.File, section *ini.Section, profile util.Profile, keys map[string]string) error {
+			return setVaultProfileKeys(section, profile, keys, opts)
+		})
+}
+
+// generateConfig merges a profile for every role of the inventory into the config at opts.ConfigPath, the keys of the
+// profiles are set by setProfileKeys
+func generateConfig(inventory *util.Inventory, opts VaultOptions, target util.ProfileTarget,
+	setProfileKeys profileKeySetter) error {
+	// the lock file is kept next to the config
+	if opts.Create && !opts.Output.DryRun {
+		if err := os.MkdirAll(filepath.Dir(opts.ConfigPath), 0o700); err != nil {
+			return util.IOError(err, "could not create the directory of config %s", opts.ConfigPath)
+		}
+	}
+
+	unlock, err := lockConfig(opts.ConfigPath, opts.Output)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	config, err := loadVaultConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	var sourceProfileSectionNames []string
+	if opts.SourceProfile != "" {
+		sourceProfileSectionNames = append(sourceProfileSectionNames, sourceProfileSectionName(opts.SourceProfile))
+	}
+
+	for _, sourceProfile := range opts.ProfileKeys.SourceProfiles() {
+		sourceProfileSectionNames = append(sourceProfileSectionNames, sourceProfileSectionName(sourceProfile))
+	}
+
+	// make sure the source sections exist
+	for _, sectionName := range sourceProfileSectionNames {
+		_, err = config.GetSection(sectionName)
+		if err == nil {
+			continue
+		}
+
+		// only the source profile of opts.SourceProfile is created, the ones of rules are expected to exist
+		if opts.SourceProfile == "" || sectionName != sourceProfileSectionName(opts.SourceProfile) ||
+			opts.CreateSourceProfile == nil {
+			return util.ConfigError(err, "source profile [%s] not found in %s", sectionName, opts.ConfigPath)
+		}
+
+		if err := createSourceProfile(config.Section(sectionName), opts.CreateSourceProfile); err != nil {
+			return err
+		}
+	}
+
+	// only copy the source profiles and generated profiles, discard the rest of the config
+	if !opts.KeepCustomConfig {
+		newConfig := ini.Empty()
+
+		for _, sectionName := range sourceProfileSectionNames {
+			if _, err := newConfig.GetSection(sectionName); err == nil {
+				continue
+			}
+
+			setProfileKey := util.GetKeySetter(newConfig.Section(sectionName))
+
+			// in file order, so that a rerun doesn't reorder the keys of the source profile
+			for _, key := range config.Section(sectionName).Keys() {
+				if err := setProfileKey(key.Name(), key.Value()); err != nil {
+					return err
+				}
+			}
+		}
+
+		config = newConfig
+	}
+
+	profiles, err := util.GetProfiles("profile ", inventory, util.ProfileOptions{
+		UseRoleName:  opts.UseRoleNameInProfile,
+		Collisions:   opts.Collisions,
+		NameTemplate: opts.NameTemplate,
+		NameRules:    opts.NameRules,
+		Aliases:      opts.Aliases,
+		Filters:      opts.Filters,
+		Stages:       opts.Stages,
+		Target:       target,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.Order != "" {
+		profiles, err = util.OrderProfilesBy(profiles, opts.Order, opts.Stages, opts.Usage)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.KeepCustomConfig {
+		pruneGeneratedSections(config, profiles)
+	}
+
+	for _, profile := range profiles {
+		keys := opts.ProfileKeys.Keys(inventory, profile, opts.Aliases, opts.Stages)
+		if err := setProfileKeys(config, claimSection(config, profile), profile, keys); err != nil {
+			return err
+		}
+	}
+
+	return saveConfig(config, opts.ConfigPath, opts.Output)
+}
+
+// loadVaultConfig loads the config at opts.ConfigPath, or starts an empty one if it doesn't exist and opts.Create is set
+func loadVaultConfig(opts VaultOptions) (*ini.File, error) {
+	config, err := ini.Load(opts.ConfigPath)
+	if err == nil {
+		return config, nil
+	}
+
+	if opts.Create && errors.Is(err, fs.ErrNotExist) {
+		log.Info().Str("file-path", opts.ConfigPath).Msg("Creating config")
+		return ini.Empty(), nil
+	}
+
+	return nil, util.IOError(err, "could not load config %s", opts.ConfigPath)
+}
+
+// createSourceProfile sets the keys of a new source profile. It isn't marked as generated, because it holds the
+// settings of your credentials, which are kept when the generated profiles are removed.
+func createSourceProfile(section *ini.Section, keysFunc func() (map[string]string, error)) error {
+	keys, err := keysFunc()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+
+	sort.Strings(names)
+
+	setKey := util.GetKeySetter(section)
+
+	for _, key := range names {
+		if err := setKey(key, keys[key]); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Str("profile", section.Name()).Strs("keys", names).Msg("Created source profile")
+
+	return nil
+}
+
+// pruneGeneratedSections removes the generated sections of roles without a profile anymore, and the keys of the others
+// so that they are written from scratch. Sections that weren't generated are left alone.
+func pruneGeneratedSections(config *ini.File, profiles []util.Profile) {
+	names := map[string]bool{}
+	for _, profile := range profiles {
+		names[profile.ProfileName] = true
+	}
+
+	for _, section := range config.Sections() {
+		if !isGenerated(section) {
+			continue
+		}
+
+		if !names[section.Name()] {
+			config.DeleteSection(section.Name())
+			log.Info().Str("profile", section.Name()).Msg("Removing stale profile")
+
+			continue
+		}
+
+		for _, key := range section.KeyStrings() {
+			section.DeleteKey(key)
+		}
+	}
+}
+
+// claimSection returns the section of a profile. New sections and those already carrying the marker are marked as
+// generated, hand-written sections of the same name keep their comment and are never pruned, only their generated keys
+// are updated.
+func claimSection(config *ini.File, profile util.Profile) *ini.Section {
+	section, err := config.GetSection(profile.ProfileName)
+	if err == nil && !isGenerated(section) {
+		log.Debug().Str("profile", profile.ProfileName).Msg("Updating hand-written profile")
+		return section
+	}
+
+	section = config.Section(profile.ProfileName)
+	section.Comment = generatedComment(profile)
+
+	return section
+}
+
+// CleanVault removes all sections written by the generator from the aws-vault config at configPath and returns their
+// names
+func CleanVault(configPath string, output OutputOptions) ([]string, error) {
+	unlock, err := lockConfig(configPath, output)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	config, err := ini.Load(configPath)
+	if err != nil {
+		return nil, util.IOError(err, "could not load config %s", configPath)
+	}
+
+	var removed []string
+
+	for _, section := range config.Sections() {
+		if isGenerated(section) {
+			config.DeleteSection(section.Name())
+			removed = append(removed, section.Name())
+		}
+	}
+
+	err = saveConfig(config, configPath, output)
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+// sourceProfileSectionName returns the section of a profile, which can either be [default] or [profile foo]
+func sourceProfileSectionName(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+
+	return fmt.Sprint("profile ", profile)
+}
+
+// setVaultProfileKeys sets the keys of a generated profile, followed by the keys of the matching rules in alphabetical
+// order
+func setVaultProfileKeys(profileSection *ini.Section, profile util.Profile, keys map[string]string,
+	opts VaultOptions) error {
+	if _, ok := keys["credential_source"]; ok {
+		return util.ConfigError(nil, "profile %s: aws-vault doesn't support credential_source, use the aws-cli command",
+			profile.ProfileName)
+	}
+
+	setKey := util.GetKeySetter(profileSection)
+
+	sourceProfile := opts.SourceProfile
+	if value, ok := keys["source_profile"]; ok {
+		sourceProfile = value
+	}
+
+	if err := setKey("role_arn", profile.RoleArn); err != nil {
+		return err
+	}
+
+	if err := setKey("source_profile", sourceProfile); err != nil {
+		return err
+	}
+
+	if err := setKey("include_profile", sourceProfile); err != nil {
+		return err
+	}
+
+	if _, ok := keys["role_session_name"]; !ok && opts.RoleSessionName != "" {
+		if err := setKey("role_session_name", opts.RoleSessionName); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := keys["region"]; !ok && opts.Region != "" {
+		if err := setKey("region", opts.Region); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		if key != "source_profile" {
+			names = append(names, key)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, key := range names {
+		if err := setKey(key, keys[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
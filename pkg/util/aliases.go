@@ -0,0 +1,80 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+)
+
+// AccountAlias overrides what is known about an account from the organization
+type AccountAlias struct {
+	// Used instead of the account name from the organization, as it is without applying name rules
+	Name string `json:"name"`
+	// The stage of the account, instead of the one its name ends in
+	Stage string `json:"stage"`
+	// The hexcode color of the account's profiles in aws-extend-switch-roles, instead of the color of its stage
+	Color string `json:"color"`
+}
+
+// Aliases maps account IDs to their aliases
+type Aliases map[string]AccountAlias
+
+type aliasFile struct {
+	Accounts Aliases `json:"accounts"`
+}
+
+var hexColor = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+// ReadAliases loads a JSON file of account aliases, usually maintained by a team, e.g.
+// {"accounts": {"123456789012": {"name": "payments-prd", "stage": "prd", "color": "ff0000"}}}
+func ReadAliases(path string) (Aliases, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, IOError(err, "could not read aliases %s", path)
+	}
+
+	var file aliasFile
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&file)
+	if err != nil {
+		return nil, ConfigError(err, "could not parse aliases %s", path)
+	}
+
+	for accountID, alias := range file.Accounts {
+		if alias.Color != "" && !hexColor.MatchString(alias.Color) {
+			return nil, ConfigError(nil, "color %q of account %s in %s is not a hexcode color", alias.Color, accountID, path)
+		}
+	}
+
+	return file.Accounts, nil
+}
+
+// accountName returns the name of an account, preferring its alias to the name from the organization
+func (a Aliases) accountName(inventory *Inventory, accountID string, rules *NameRules) string {
+	if alias := a[accountID]; alias.Name != "" {
+		return alias.Name
+	}
+
+	if name, ok := inventory.Accounts[accountID]; ok {
+		return rules.Apply(name)
+	}
+
+	return accountID
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetProfilesWithAliases(t *testing.T) {
+	rules := &NameRules{Lowercase: true}
+	if err := rules.Compile(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "Legacy-Account", "67890": "Payments-Dev"},
+		RoleArns: []string{
+			"arn:aws:iam::12345:role/admin",
+			"arn:aws:iam::67890:role/admin",
+			"arn:aws:iam::99999:role/admin",
+		},
+	}
+	aliases := Aliases{
+		"12345": {Name: "Payments-PRD", Stage: "prd"},
+		"99999": {Name: "partner", Color: "0000ff"},
+	}
+
+	profiles, err := GetProfiles("", inventory, ProfileOptions{NameRules: rules, Aliases: aliases})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []Profile{
+		// alias names are used as they are, without applying the name rules
+		{ProfileName: "Payments-PRD", Stage: "prd"},
+		{ProfileName: "payments-dev", Stage: "dev"},
+		{ProfileName: "partner", Color: "0000ff"},
+	}
+	for i, profile := range profiles {
+		if profile.ProfileName != want[i].ProfileName || profile.Stage != want[i].Stage || profile.Color != want[i].Color {
+			t.Errorf("GetProfiles()[%d] = %+v, want %+v", i, profile, want[i])
+		}
+	}
+
+	nameTemplate, err := ParseProfileNameTemplate("{{.Stage}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	profiles, err = GetProfiles("", &Inventory{RoleArns: inventory.RoleArns[:1]}, ProfileOptions{NameTemplate: nameTemplate, Aliases: aliases})
+	if err != nil || profiles[0].ProfileName != "prd" {
+		t.Errorf("expected the stage of the alias in the template, got %v, %v", profiles, err)
+	}
+}
+
+func TestReadAliases(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid", content: `{"accounts": {"12345": {"name": "payments", "stage": "prd", "color": "FF0000"}}}`},
+		{name: "unknown key", content: `{"accounts": {"12345": {"nmae": "payments"}}}`, wantErr: true},
+		{name: "invalid color", content: `{"accounts": {"12345": {"color": "#ff0000"}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			aliases, err := ReadAliases(path)
+			if tt.wantErr {
+				if ErrorKindOf(err) != KindConfig {
+					t.Errorf("ReadAliases() error = %v, want a config error", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if aliases["12345"].Name != "payments" {
+				t.Errorf("ReadAliases() = %v", aliases)
+			}
+		})
+	}
+}
@@ -14,19 +14,25 @@ package util
 */
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
 
 type PolicyDoc struct {
@@ -39,56 +45,275 @@ const (
 )
 
 type AWSContext struct {
-	org *organizations.Organizations
-	iam *iam.IAM
-	sts *sts.STS
+	org organizationsiface.OrganizationsAPI
+	iam iamiface.IAMAPI
+	sts stsiface.STSAPI
+
+	// all API calls are cancelled when this context is done
+	context context.Context
+	// the maximum duration of a single API call, unlimited if 0
+	callTimeout time.Duration
+	// whether discovery looks up the organizational unit and tags of every account
+	accountDetails bool
+	// the region of the session the clients were created with, if known
+	region string
+	// the custom endpoint URLs of the clients keyed by endpoint ID
+	endpoints map[string]string
+
+	// number of policies that were skipped because they could not be read
+	skippedPolicies int32
 }
 
-func GetAWSContext() (client *AWSContext) {
-	sess := session.Must(session.NewSession())
+func GetAWSContext(cfg AWSConfig) (*AWSContext, error) {
+	sess, err := newSession(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	config := aws.NewConfig()
 
+	orgClient := organizations.New(sess, config)
+	iamClient := iam.New(sess, config)
+	stsClient := sts.New(sess, config)
+
+	err = cfg.instrument(&orgClient.Handlers, &iamClient.Handlers, &stsClient.Handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	awsContext := NewAWSContext(orgClient, iamClient, stsClient).WithCallTimeout(cfg.CallTimeout)
+	awsContext.region = aws.StringValue(sess.Config.Region)
+	awsContext.endpoints = cfg.Endpoints
+
+	return awsContext, nil
+}
+
+// NewAWSContext creates a context from existing clients, e.g. to supply fakes in tests
+func NewAWSContext(org organizationsiface.OrganizationsAPI, iam iamiface.IAMAPI, sts stsiface.STSAPI) *AWSContext {
+	return &AWSContext{
+		org:     org,
+		iam:     iam,
+		sts:     sts,
+		context: context.Background(),
+	}
+}
+
+// WithContext returns a copy of the AWSContext whose API calls are cancelled when c is done
+func (ctx *AWSContext) WithContext(c context.Context) *AWSContext {
 	return &AWSContext{
-		org: organizations.New(sess, config),
-		iam: iam.New(sess, config),
-		sts: sts.New(sess, config),
+		org:            ctx.org,
+		iam:            ctx.iam,
+		sts:            ctx.sts,
+		context:        c,
+		callTimeout:    ctx.callTimeout,
+		accountDetails: ctx.accountDetails,
+		region:         ctx.region,
+		endpoints:      ctx.endpoints,
+	}
+}
+
+// Region is the region API calls are made in, empty if it isn't known, e.g. for clients created by NewAWSContext
+func (ctx *AWSContext) Region() string {
+	return ctx.region
+}
+
+// WithCallTimeout returns a copy of the AWSContext that cancels every API call taking longer than timeout
+func (ctx *AWSContext) WithCallTimeout(timeout time.Duration) *AWSContext {
+	awsContext := ctx.WithContext(ctx.context)
+	awsContext.callTimeout = timeout
+
+	return awsContext
+}
+
+// WithAccountDetails returns a copy of the AWSContext whose discovery includes the organizational unit and tags of
+// every account, which takes a few extra API calls per account
+func (ctx *AWSContext) WithAccountDetails() *AWSContext {
+	awsContext := ctx.WithContext(ctx.context)
+	awsContext.accountDetails = true
+
+	return awsContext
+}
+
+// callContext returns the context for a single API call
+func (ctx *AWSContext) callContext() (context.Context, context.CancelFunc) {
+	if ctx.callTimeout <= 0 {
+		return context.WithCancel(ctx.context)
 	}
+
+	return context.WithTimeout(ctx.context, ctx.callTimeout)
 }
 
+// generateOrgRoleArns returns the ARNs of role in every account, sorted by account ID so that the order doesn't depend
+// on the order of the map
 func generateOrgRoleArns(accountMap map[string]string, role string) []string {
-	var roles []string
+	accountIDs := make([]string, 0, len(accountMap))
+	for accountID := range accountMap {
+		accountIDs = append(accountIDs, accountID)
+	}
 
-	for accountId := range accountMap {
-		roles = append(roles, fmt.Sprintf("arn:aws:iam::%s:role/%s", accountId, role))
+	slices.Sort(accountIDs)
+
+	roles := make([]string, 0, len(accountIDs))
+	for _, accountID := range accountIDs {
+		roles = append(roles, fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, role))
 	}
 
 	return roles
 }
 
-func (ctx *AWSContext) GetRolesAndAccounts(role string) (roleArns []string, accountMap map[string]string) {
-	cRoles := make(chan []string)
-	cAccount := make(chan map[string]string)
+// grantsResult is used to pass the result of a concurrent lookup back over a channel
+type grantsResult struct {
+	grants []Grant
+	err    error
+}
 
-	go func() {
-		cRoles <- ctx.getRoles()
-	}()
+// accountsResult is used to pass the account names of the organization back over a channel
+type accountsResult struct {
+	accounts map[string]string
+	err      error
+}
 
+// goLookup runs lookup in a goroutine and sends its result to c, reporting a panic as the result of failed instead of
+// crashing. c must be buffered, so that the goroutine can exit even if nobody receives the result anymore.
+func goLookup[T any](c chan<- T, lookup func() T, failed func(err error) T) {
 	go func() {
-		cAccount <- ctx.getAccountNames()
+		defer func() {
+			if r := recover(); r != nil {
+				c <- failed(newError(KindUnknown, nil, "discovery failed unexpectedly: %v", r))
+			}
+		}()
+
+		c <- lookup()
 	}()
+}
+
+// goGrants runs lookup in a goroutine like goLookup
+func goGrants(c chan<- grantsResult, lookup func() grantsResult) {
+	goLookup(c, lookup, func(err error) grantsResult {
+		return grantsResult{err: err}
+	})
+}
 
-	accountMap = <-cAccount
-	close(cAccount)
+// collectGrants receives n results from c and stops at the first error or when the context is done
+func (ctx *AWSContext) collectGrants(c <-chan grantsResult, n int) (result grantsResult) {
+	for i := 0; i < n; i++ {
+		select {
+		case lookupResult := <-c:
+			if lookupResult.err != nil {
+				return grantsResult{err: lookupResult.err}
+			}
 
-	if role != "" {
-		roleArns = generateOrgRoleArns(accountMap, role)
+			result.grants = append(result.grants, lookupResult.grants...)
+		case <-ctx.context.Done():
+			return grantsResult{err: ContextError(ctx.context.Err())}
+		}
 	}
 
-	roleArns = append(roleArns, <-cRoles...)
-	close(cRoles)
+	return result
+}
+
+// caller is the identity that roles are discovered for
+type caller struct {
+	arn    string
+	groups []*iam.Group
+	// the name of the role if the caller is an assumed role session, e.g. the instance profile of an EC2 instance
+	role string
+}
 
-	return
+// grantOwner is the IAM group or role whose policies grant roles
+type grantOwner struct {
+	group string
+	role  string
+}
+
+func (o grantOwner) String() string {
+	if o.role != "" {
+		return "role " + o.role
+	}
+
+	return "group " + o.group
+}
+
+// GetRolesAndAccounts discovers an inventory of the roles the caller may assume and the accounts of the organization.
+// If role is set, it is added for every account in the organization.
+func (ctx *AWSContext) GetRolesAndAccounts(role string) (*Inventory, error) {
+	caller, err := ctx.getCaller()
+	if err != nil {
+		return nil, err
+	}
+
+	inventory, err := ctx.discover(caller)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory.AddOrgRole(role)
+
+	return inventory, nil
+}
+
+func (ctx *AWSContext) discover(caller *caller) (*Inventory, error) {
+	// stops the lookups that are still running once the first one failed
+	c, cancel := context.WithCancel(ctx.context)
+	defer cancel()
+
+	ctx = ctx.WithContext(c)
+
+	cRoles := make(chan grantsResult, 1)
+	cAccount := make(chan accountsResult, 1)
+
+	goGrants(cRoles, func() grantsResult {
+		return ctx.getRoles(caller)
+	})
+
+	goLookup(cAccount, func() accountsResult {
+		return accountsResult{accounts: ctx.getAccountNames()}
+	}, func(err error) accountsResult {
+		return accountsResult{err: err}
+	})
+
+	roles := ctx.collectGrants(cRoles, 1)
+	if roles.err != nil {
+		return nil, roles.err
+	}
+
+	var accountMap map[string]string
+
+	select {
+	case accounts := <-cAccount:
+		if accounts.err != nil {
+			return nil, accounts.err
+		}
+
+		accountMap = accounts.accounts
+	case <-ctx.context.Done():
+	}
+
+	var accountDetails map[string]AccountDetails
+	if ctx.accountDetails && ctx.context.Err() == nil {
+		var err error
+
+		accountDetails, err = ctx.getAccountDetails(accountMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a failing ListAccounts call is only logged, so make sure that a cancelled one doesn't yield a partial inventory
+	if err := ctx.context.Err(); err != nil {
+		return nil, ContextError(err)
+	}
+
+	skipped := atomic.LoadInt32(&ctx.skippedPolicies)
+	if skipped > 0 {
+		log.Warn().Msgf("%d policies could not be read, the generated config may be incomplete", skipped)
+	}
+
+	inventory := newInventory(caller.arn, accountMap, roles.grants)
+	inventory.SkippedPolicies = int(skipped)
+	inventory.AccountDetails = accountDetails
+
+	return inventory, nil
 }
 
 type Profile struct {
@@ -96,86 +321,330 @@ type Profile struct {
 	RoleName    string
 	ProfileName string
 	AccountID   string
+	// the policies granting the role, empty for roles added with --role
+	Grants []Grant
+	// the name of the account as used in profile names
+	AccountName string
+	// the OU path of the account, only known if discovered with account details
+	OUPath string
+	// the stage of the account or profile, empty if it doesn't belong to one
+	Stage string
+	// the color set for the account in the aliases or for its stage, if any
+	Color string
+}
+
+// ProfileOptions controls how profiles are derived from an inventory
+type ProfileOptions struct {
+	// Append the role name to the profile name
+	UseRoleName bool
+	// How profiles of different roles with the same name are told apart
+	Collisions CollisionStrategy
+	// If set, profile names are rendered from this template instead, and UseRoleName is ignored
+	NameTemplate *ProfileNameTemplate
+	// If set, account and role names are normalized with these rules before they are used in profile names
+	NameRules *NameRules
+	// The tool the profile names have to be valid for
+	Target ProfileTarget
+	// Account names, stages and colors taking precedence over what is known from the organization
+	Aliases Aliases
+	// If set, only roles passing these filters get a profile
+	Filters *Filters
+	// The stages accounts belong to, defaults to DefaultStageModel
+	Stages *StageModel
 }
 
-func GetProfiles(prefix string, accountMap map[string]string, roleArns []string, useRoleName bool) []Profile {
+func (opts ProfileOptions) stages() *StageModel {
+	if opts.Stages == nil {
+		return DefaultStageModel()
+	}
+
+	return opts.Stages
+}
+
+// GetProfiles returns a profile for every distinct role of the inventory with a unique name starting with prefix
+func GetProfiles(prefix string, inventory *Inventory, opts ProfileOptions) ([]Profile, error) {
 	var profiles []Profile
 
-	for _, roleArn := range roleArns {
+	if opts.Filters != nil {
+		filtered, results, err := opts.Filters.Apply(inventory, opts.Aliases, opts.NameRules, opts.stages())
+		if err != nil {
+			return nil, err
+		}
+
+		logFilterResults(results)
+		inventory = filtered
+	}
+
+	grants := inventory.grantsByRole()
+	seen := map[string]bool{}
+
+	for _, roleArn := range inventory.RoleArns {
 		// skip creating this profile if the role isn't a valid ARN (e.g. `*`)
 		if !arn.IsARN(roleArn) {
 			continue
 		}
 
+		// several policies may grant the same role
+		if seen[roleArn] {
+			log.Debug().Str("role", roleArn).Msg("skipping duplicate role")
+			continue
+		}
+
+		seen[roleArn] = true
+
 		role, _ := arn.Parse(roleArn)
-		profileName, roleName := getProfileAndRoleName(accountMap, role, useRoleName)
+		roleName := strings.Replace(role.Resource, "role/", "", 1)
+		data := profileNameData(inventory, role, grants[roleArn], opts)
+
+		profileName := data.Account
+		if opts.UseRoleName {
+			profileName = fmt.Sprint(profileName, "_", opts.NameRules.Apply(roleName))
+		}
+
+		if opts.NameTemplate != nil {
+			var err error
+
+			profileName, err = opts.NameTemplate.render(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// e.g. for a profile name template ending in the stage of the role rather than the account
+		stage := data.Stage
+		if stage == "" {
+			stage = opts.stages().match(AccountDetails{}, profileName)
+		}
+
+		color := opts.Aliases[role.AccountID].Color
+		if matched := opts.stages().Stage(stage); color == "" && matched != nil {
+			color = matched.Color
+		}
 
 		profiles = append(profiles, Profile{
 			RoleArn:     roleArn,
 			RoleName:    roleName,
-			ProfileName: fmt.Sprint(prefix, profileName),
+			ProfileName: profileName,
 			AccountID:   role.AccountID,
+			AccountName: data.Account,
+			OUPath:      data.OUPath,
+			Grants:      grants[roleArn],
+			Stage:       stage,
+			Color:       color,
 		})
 	}
 
-	return profiles
+	profiles, err := ResolveCollisions(profiles, opts.Collisions)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range profiles {
+		if err := opts.Target.validate(profiles[i].ProfileName); err != nil {
+			return nil, ConfigError(err, "invalid profile name for role %s", profiles[i].RoleArn)
+		}
+
+		profiles[i].ProfileName = fmt.Sprint(prefix, profiles[i].ProfileName)
+	}
+
+	return profiles, nil
 }
 
-func getProfileAndRoleName(accountMap map[string]string, role arn.ARN, useRoleName bool) (profileName string, roleName string) {
-	if name, ok := accountMap[role.AccountID]; ok {
-		profileName = name
-	} else {
-		profileName = role.AccountID
+func profileNameData(inventory *Inventory, role arn.ARN, grants []Grant, opts ProfileOptions) ProfileNameData {
+	account := opts.Aliases.accountName(inventory, role.AccountID, opts.NameRules)
+
+	details := inventory.AccountDetails[role.AccountID]
+
+	data := ProfileNameData{
+		Account:   account,
+		AccountID: role.AccountID,
+		Role:      strings.TrimPrefix(role.Resource, "role/"),
+		RolePath:  "/",
+		OUPath:    details.OUPath,
+		Stage:     accountStage(inventory, role.AccountID, opts.Aliases, opts.NameRules, opts.stages()),
+		Tags:      details.Tags,
 	}
 
-	roleName = strings.Replace(role.Resource, "role/", "", 1)
+	if i := strings.LastIndex(data.Role, "/"); i >= 0 {
+		data.RolePath = "/" + data.Role[:i+1]
+		data.Role = data.Role[i+1:]
+	}
 
-	if useRoleName {
-		profileName = fmt.Sprint(profileName, "_", roleName)
+	data.Role = opts.NameRules.Apply(data.Role)
+
+	for _, grant := range grants {
+		if grant.Group != "" && !slices.Contains(data.Groups, grant.Group) {
+			data.Groups = append(data.Groups, grant.Group)
+		}
 	}
 
-	return
+	if len(data.Groups) > 0 {
+		data.Group = data.Groups[0]
+	}
+
+	return data
 }
 
+// getUser returns the name of an IAM user from its ARN, which is the last segment of the resource if the user has a
+// path like arn:aws:iam::123456789012:user/engineering/jane
 func getUser(userArn *string) *string {
-	arnParts := strings.Split(*userArn, "/")
-	return &arnParts[1]
+	user := (*userArn)[strings.LastIndex(*userArn, "/")+1:]
+	return &user
 }
 
-func (ctx *AWSContext) getRoles() (roleArns []string) {
+func (ctx *AWSContext) getCaller() (*caller, error) {
 	log.Debug().Msg("getting caller identity")
 
-	gcio, err := ctx.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	gcio, err := ctx.sts.GetCallerIdentityWithContext(callCtx, &sts.GetCallerIdentityInput{})
 	if err != nil {
-		log.Panic().Err(err).Msg("could not get caller identity")
+		return nil, awsError(err, "could not get caller identity")
+	}
+
+	// on CI runners and EC2 or ECS hosts, the credentials are those of a role session, which has no groups
+	if role := assumedRoleName(*gcio.Arn); role != "" {
+		log.Info().Str("role-arn", *gcio.Arn).Msg("Found role")
+
+		return &caller{arn: *gcio.Arn, role: role}, nil
 	}
 
 	log.Info().Str("user-arn", *gcio.Arn).Msg("Found user")
 
-	lgfuo, err := ctx.iam.ListGroupsForUser(&iam.ListGroupsForUserInput{
+	callCtx, cancel = ctx.callContext()
+	defer cancel()
+
+	lgfuo, err := ctx.iam.ListGroupsForUserWithContext(callCtx, &iam.ListGroupsForUserInput{
 		UserName: getUser(gcio.Arn),
 	})
 	if err != nil {
-		log.Panic().Err(err).Str("user", *getUser(gcio.Arn)).Msg("could not list groups for user")
+		return nil, awsError(err, "could not list groups for user %s", *getUser(gcio.Arn))
 	}
 
 	log.Debug().Msgf("Found %d groups", len(lgfuo.Groups))
 
-	c := make(chan []string)
+	return &caller{arn: *gcio.Arn, groups: lgfuo.Groups}, nil
+}
+
+// assumedRoleName returns the name of the role of an assumed role session ARN like
+// arn:aws:sts::123456789012:assumed-role/build-agent/i-0123456789abcdef0, or "" for other ARNs
+func assumedRoleName(callerArn string) string {
+	parsed, err := arn.Parse(callerArn)
+	if err != nil || parsed.Service != "sts" {
+		return ""
+	}
+
+	parts := strings.Split(parsed.Resource, "/")
+	if len(parts) < 2 || parts[0] != "assumed-role" {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// GetMFASerial returns the serial number of the first MFA device of the calling IAM user, or "" if it has none or the
+// caller is an assumed role session
+func (ctx *AWSContext) GetMFASerial() (string, error) {
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	gcio, err := ctx.sts.GetCallerIdentityWithContext(callCtx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", awsError(err, "could not get caller identity")
+	}
+
+	if assumedRoleName(*gcio.Arn) != "" {
+		log.Debug().Str("role-arn", *gcio.Arn).Msg("assumed role sessions have no MFA devices")
+		return "", nil
+	}
+
+	callCtx, cancel = ctx.callContext()
+	defer cancel()
+
+	lmdo, err := ctx.iam.ListMFADevicesWithContext(callCtx, &iam.ListMFADevicesInput{UserName: getUser(gcio.Arn)})
+	if err != nil {
+		return "", awsError(err, "could not list MFA devices of user %s", *getUser(gcio.Arn))
+	}
+
+	if len(lmdo.MFADevices) == 0 {
+		return "", nil
+	}
+
+	if len(lmdo.MFADevices) > 1 {
+		log.Warn().Int("devices", len(lmdo.MFADevices)).Msg("user has several MFA devices, using the first one")
+	}
+
+	return aws.StringValue(lmdo.MFADevices[0].SerialNumber), nil
+}
+
+// GetSessionNameData returns the identity of the caller for role session name templates. The tags of an IAM user are
+// only looked up if userTags is set, assumed role sessions have none.
+func (ctx *AWSContext) GetSessionNameData(userTags bool) (SessionNameData, error) {
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	gcio, err := ctx.sts.GetCallerIdentityWithContext(callCtx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return SessionNameData{}, awsError(err, "could not get caller identity")
+	}
+
+	data := NewSessionNameData(*gcio.Arn)
+	if !userTags || assumedRoleName(*gcio.Arn) != "" {
+		return data, nil
+	}
+
+	callCtx, cancel = ctx.callContext()
+	defer cancel()
+
+	luto, err := ctx.iam.ListUserTagsWithContext(callCtx, &iam.ListUserTagsInput{UserName: getUser(gcio.Arn)})
+	if err != nil {
+		return SessionNameData{}, awsError(err, "could not list tags of user %s", *getUser(gcio.Arn))
+	}
+
+	data.Tags = map[string]string{}
+
+	for _, tag := range luto.Tags {
+		data.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+
+		if strings.EqualFold(aws.StringValue(tag.Key), "email") {
+			data.Email = aws.StringValue(tag.Value)
+		}
+	}
+
+	return data, nil
+}
 
-	for _, group := range lgfuo.Groups {
-		go func(g iam.Group) {
+func (ctx *AWSContext) getRoles(caller *caller) (result grantsResult) {
+	if caller.role != "" {
+		log.Debug().Str("role", caller.role).Msg("Finding roles for the policies of the role")
+
+		result = ctx.getGrantsForRole(caller.role)
+		if result.err == nil {
+			log.Info().Msgf("Found %d roles", len(result.grants))
+		}
+
+		return
+	}
+
+	c := make(chan grantsResult, len(caller.groups))
+
+	for _, group := range caller.groups {
+		g := *group
+
+		goGrants(c, func() grantsResult {
 			log.Debug().Str("group", *g.GroupName).Msg("Finding roles for group")
-			c <- ctx.getRoleArnsForGroup(&g)
-		}(*group)
+			return ctx.getGrantsForGroup(&g)
+		})
 	}
 
-	for range lgfuo.Groups {
-		roleArns = append(roleArns, (<-c)...)
+	result = ctx.collectGrants(c, len(caller.groups))
+	if result.err != nil {
+		return
 	}
 
-	log.Info().Msgf("Found %d roles", len(roleArns))
-	log.Debug().Strs("roles", roleArns).Msgf("Roles")
+	log.Info().Msgf("Found %d roles", len(result.grants))
+	log.Debug().Strs("roles", grantedRoleArns(result.grants)).Msgf("Roles")
 
 	return
 }
@@ -186,7 +655,10 @@ func (ctx *AWSContext) getAccountNames() map[string]string {
 	lai := &organizations.ListAccountsInput{}
 
 	for {
-		lao, err := ctx.org.ListAccounts(lai)
+		callCtx, cancel := ctx.callContext()
+		lao, err := ctx.org.ListAccountsWithContext(callCtx, lai)
+		cancel()
+
 		if err != nil {
 			log.Warn().Err(err).Msg("could not list organization member accounts")
 			// ignore error so script can be used without these permissions
@@ -213,118 +685,241 @@ func (ctx *AWSContext) getAccountNames() map[string]string {
 	return accIDToName
 }
 
-func (ctx *AWSContext) getRoleArnsForGroup(group *iam.Group) (roles []string) {
-	c := make(chan []string)
+func (ctx *AWSContext) getGrantsForGroup(group *iam.Group) grantsResult {
+	c := make(chan grantsResult, 2)
 
-	go func() {
-		c <- ctx.listInlinePolicyAndGetRoles(group)
-	}()
-	go func() {
-		c <- ctx.listAttachedPolicyAndGetRoles(group)
-	}()
+	goGrants(c, func() grantsResult {
+		return ctx.listInlinePolicyAndGetGrants(group)
+	})
+	goGrants(c, func() grantsResult {
+		return ctx.listAttachedPolicyAndGetGrants(group)
+	})
 
-	roles = append(roles, (<-c)...)
-	roles = append(roles, (<-c)...)
+	return ctx.collectGrants(c, 2)
+}
 
-	return
+// getGrantsForRole finds the roles that the inline and attached policies of a role allow to assume
+func (ctx *AWSContext) getGrantsForRole(role string) grantsResult {
+	c := make(chan grantsResult, 2)
+
+	goGrants(c, func() grantsResult {
+		return ctx.listRoleInlinePolicyAndGetGrants(role)
+	})
+	goGrants(c, func() grantsResult {
+		return ctx.listRoleAttachedPolicyAndGetGrants(role)
+	})
+
+	return ctx.collectGrants(c, 2)
+}
+
+// policyGrants turns the roles of a policy into grants. A policy that could not be read is reported without aborting
+// the whole run, unless reading it failed because discovery was cancelled.
+func (ctx *AWSContext) policyGrants(grants []Grant, err error, owner grantOwner, policy string) grantsResult {
+	if err != nil {
+		if ctx.context.Err() != nil {
+			return grantsResult{err: err}
+		}
+
+		atomic.AddInt32(&ctx.skippedPolicies, 1)
+		log.Warn().Err(err).Stringer("owner", owner).Str("policy", policy).Msg("skipping policy that could not be read")
+	}
+
+	for i := range grants {
+		grants[i].Group = owner.group
+		grants[i].SourceRole = owner.role
+		grants[i].Policy = policy
+	}
+
+	return grantsResult{grants: grants}
 }
 
-func (ctx *AWSContext) listInlinePolicyAndGetRoles(group *iam.Group) (roleArns []string) {
+func (ctx *AWSContext) listInlinePolicyAndGetGrants(group *iam.Group) (result grantsResult) {
 	log.Debug().Str("group", *group.GroupName).Msg("finding roles from group inline policies")
 
-	lgpo, err := ctx.iam.ListGroupPolicies(&iam.ListGroupPoliciesInput{
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	lgpo, err := ctx.iam.ListGroupPoliciesWithContext(callCtx, &iam.ListGroupPoliciesInput{
 		GroupName: group.GroupName,
 	})
 	if err != nil {
-		log.Panic().Err(err).Str("group", *group.GroupName).Msg("could not list inline group policies")
+		result.err = awsError(err, "could not list inline policies of group %s", *group.GroupName)
+		return
 	}
 
-	c := make(chan []string)
+	c := make(chan grantsResult, len(lgpo.PolicyNames))
 
 	for _, policy := range lgpo.PolicyNames {
-		go func(p string) {
+		p := *policy
+
+		goGrants(c, func() grantsResult {
 			log.Debug().Str("policy", p).Msg("Finding roles for inlined policy")
-			c <- ctx.getRoleArnsForInlinePolicy(*group.GroupName, p)
-		}(*policy)
-	}
 
-	for range lgpo.PolicyNames {
-		roleArns = append(roleArns, (<-c)...)
+			grants, err := ctx.getGrantsForInlinePolicy(*group.GroupName, p)
+			return ctx.policyGrants(grants, err, grantOwner{group: *group.GroupName}, p)
+		})
 	}
 
-	return
+	return ctx.collectGrants(c, len(lgpo.PolicyNames))
 }
 
-func (ctx *AWSContext) getRoleArnsForInlinePolicy(group, policyName string) []string {
-	ggpo, err := ctx.iam.GetGroupPolicy(&iam.GetGroupPolicyInput{
+func (ctx *AWSContext) getGrantsForInlinePolicy(group, policyName string) ([]Grant, error) {
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	ggpo, err := ctx.iam.GetGroupPolicyWithContext(callCtx, &iam.GetGroupPolicyInput{
 		GroupName:  &group,
 		PolicyName: &policyName,
 	})
 	if err != nil {
-		log.Panic().Err(err).Msg("could not get group policy")
+		return nil, awsError(err, "could not get group policy %s", policyName)
 	}
 
-	return getRolesArnsFromPolicy(ggpo.PolicyDocument)
+	return getGrantsFromPolicy(ggpo.PolicyDocument)
 }
 
-func (ctx *AWSContext) listAttachedPolicyAndGetRoles(group *iam.Group) (roleArns []string) {
+func (ctx *AWSContext) listAttachedPolicyAndGetGrants(group *iam.Group) (result grantsResult) {
 	log.Debug().Str("group", *group.GroupName).Msg("finding roles from group attached policies")
 
-	lagpo, err := ctx.iam.ListAttachedGroupPolicies(&iam.ListAttachedGroupPoliciesInput{
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	lagpo, err := ctx.iam.ListAttachedGroupPoliciesWithContext(callCtx, &iam.ListAttachedGroupPoliciesInput{
 		GroupName: group.GroupName,
 	})
 	if err != nil {
-		log.Panic().Err(err).Str("group", *group.GroupName).Msg("could not list attached group policies")
+		result.err = awsError(err, "could not list attached policies of group %s", *group.GroupName)
+		return
 	}
 
-	c := make(chan []string)
+	c := make(chan grantsResult, len(lagpo.AttachedPolicies))
 
 	for _, policy := range lagpo.AttachedPolicies {
-		go func(p iam.AttachedPolicy) {
+		p := *policy
+
+		goGrants(c, func() grantsResult {
 			log.Debug().Str("policy ARN", *p.PolicyArn).Msg("Finding roles for attached policy")
-			c <- ctx.getRoleArnsForAttachedPolicy(&p)
-		}(*policy)
+
+			grants, err := ctx.getGrantsForAttachedPolicy(&p)
+			return ctx.policyGrants(grants, err, grantOwner{group: *group.GroupName}, *p.PolicyArn)
+		})
 	}
 
-	for range lagpo.AttachedPolicies {
-		roleArns = append(roleArns, (<-c)...)
+	return ctx.collectGrants(c, len(lagpo.AttachedPolicies))
+}
+
+func (ctx *AWSContext) listRoleInlinePolicyAndGetGrants(role string) (result grantsResult) {
+	log.Debug().Str("role", role).Msg("finding roles from role inline policies")
+
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	lrpo, err := ctx.iam.ListRolePoliciesWithContext(callCtx, &iam.ListRolePoliciesInput{
+		RoleName: &role,
+	})
+	if err != nil {
+		result.err = awsError(err, "could not list inline policies of role %s", role)
+		return
 	}
 
-	return
+	c := make(chan grantsResult, len(lrpo.PolicyNames))
+
+	for _, policy := range lrpo.PolicyNames {
+		p := *policy
+
+		goGrants(c, func() grantsResult {
+			log.Debug().Str("policy", p).Msg("Finding roles for inlined policy")
+
+			grants, err := ctx.getGrantsForRoleInlinePolicy(role, p)
+			return ctx.policyGrants(grants, err, grantOwner{role: role}, p)
+		})
+	}
+
+	return ctx.collectGrants(c, len(lrpo.PolicyNames))
+}
+
+func (ctx *AWSContext) getGrantsForRoleInlinePolicy(role, policyName string) ([]Grant, error) {
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	grpo, err := ctx.iam.GetRolePolicyWithContext(callCtx, &iam.GetRolePolicyInput{
+		RoleName:   &role,
+		PolicyName: &policyName,
+	})
+	if err != nil {
+		return nil, awsError(err, "could not get role policy %s", policyName)
+	}
+
+	return getGrantsFromPolicy(grpo.PolicyDocument)
 }
 
-func (ctx *AWSContext) getRoleArnsForAttachedPolicy(policy *iam.AttachedPolicy) []string {
-	gpio, err := ctx.iam.GetPolicy(&iam.GetPolicyInput{
+func (ctx *AWSContext) listRoleAttachedPolicyAndGetGrants(role string) (result grantsResult) {
+	log.Debug().Str("role", role).Msg("finding roles from role attached policies")
+
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	larpo, err := ctx.iam.ListAttachedRolePoliciesWithContext(callCtx, &iam.ListAttachedRolePoliciesInput{
+		RoleName: &role,
+	})
+	if err != nil {
+		result.err = awsError(err, "could not list attached policies of role %s", role)
+		return
+	}
+
+	c := make(chan grantsResult, len(larpo.AttachedPolicies))
+
+	for _, policy := range larpo.AttachedPolicies {
+		p := *policy
+
+		goGrants(c, func() grantsResult {
+			log.Debug().Str("policy ARN", *p.PolicyArn).Msg("Finding roles for attached policy")
+
+			grants, err := ctx.getGrantsForAttachedPolicy(&p)
+			return ctx.policyGrants(grants, err, grantOwner{role: role}, *p.PolicyArn)
+		})
+	}
+
+	return ctx.collectGrants(c, len(larpo.AttachedPolicies))
+}
+
+func (ctx *AWSContext) getGrantsForAttachedPolicy(policy *iam.AttachedPolicy) ([]Grant, error) {
+	callCtx, cancel := ctx.callContext()
+	defer cancel()
+
+	gpio, err := ctx.iam.GetPolicyWithContext(callCtx, &iam.GetPolicyInput{
 		PolicyArn: policy.PolicyArn,
 	})
 	if err != nil {
-		log.Panic().Err(err).Str("policy-arn", *policy.PolicyArn).Msg("could not get policy")
+		return nil, awsError(err, "could not get policy %s", *policy.PolicyArn)
 	}
 
-	gpvio, err := ctx.iam.GetPolicyVersion(&iam.GetPolicyVersionInput{
+	callCtx, cancel = ctx.callContext()
+	defer cancel()
+
+	gpvio, err := ctx.iam.GetPolicyVersionWithContext(callCtx, &iam.GetPolicyVersionInput{
 		PolicyArn: policy.PolicyArn,
 		VersionId: gpio.Policy.DefaultVersionId,
 	})
 	if err != nil {
-		log.Panic().Err(err).
-			Str("policy", *policy.PolicyArn).Str("version-id", *gpio.Policy.DefaultVersionId).
-			Msg("could not get policy version")
+		return nil, awsError(err, "could not get version %s of policy %s", *gpio.Policy.DefaultVersionId, *policy.PolicyArn)
 	}
 
-	return getRolesArnsFromPolicy(gpvio.PolicyVersion.Document)
+	return getGrantsFromPolicy(gpvio.PolicyVersion.Document)
 }
 
-func getRolesArnsFromPolicy(policyJSON *string) (roles []string) {
+// getGrantsFromPolicy returns a grant with the role and statement ID for every role the policy allows to assume
+func getGrantsFromPolicy(policyJSON *string) (grants []Grant, err error) {
 	policyJson, err := url.QueryUnescape(*policyJSON)
 	if err != nil {
-		log.Panic().Err(err).Msg("could not unescape policy JSON")
+		return nil, ConfigError(err, "could not unescape policy JSON")
 	}
 
 	var policyDoc PolicyDoc
 
 	err = json.Unmarshal([]byte(policyJson), &policyDoc)
 	if err != nil {
-		log.Panic().Err(err).Msg("could not unmarshall policy JSON")
+		return nil, ConfigError(err, "could not unmarshall policy JSON")
 	}
 
 	for _, statement := range policyDoc.Statement {
@@ -332,9 +927,11 @@ func getRolesArnsFromPolicy(policyJSON *string) (roles []string) {
 			continue
 		}
 
+		sid, _ := statement["Sid"].(string)
+
 		if resStr, ok := statement["Resource"].(string); ok {
 			log.Debug().Str("role", resStr).Msg("found assumable role")
-			roles = append(roles, resStr)
+			grants = append(grants, Grant{RoleArn: resStr, Sid: sid})
 			continue
 		}
 
@@ -342,13 +939,13 @@ func getRolesArnsFromPolicy(policyJSON *string) (roles []string) {
 			for _, res := range resArr {
 				if resStr, ok := res.(string); ok {
 					log.Debug().Str("role", resStr).Msg("found assumable role")
-					roles = append(roles, resStr)
+					grants = append(grants, Grant{RoleArn: resStr, Sid: sid})
 				}
 			}
 		}
 	}
 
-	return
+	return grants, nil
 }
 
 func checkAction(action interface{}) bool {
@@ -0,0 +1,649 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+func policyDocument(resources ...string) *string {
+	doc := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"sts:AssumeRole","Resource":[`
+
+	for i, resource := range resources {
+		if i > 0 {
+			doc += ","
+		}
+		doc += `"` + resource + `"`
+	}
+
+	return aws.String(url.QueryEscape(doc + `]}]}`))
+}
+
+type fakeSTS struct {
+	stsiface.STSAPI
+	err error
+	// the caller's ARN, defaults to the IAM user jane
+	arn string
+}
+
+func (f *fakeSTS) GetCallerIdentityWithContext(_ aws.Context, _ *sts.GetCallerIdentityInput, _ ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	if f.arn != "" {
+		return &sts.GetCallerIdentityOutput{Arn: aws.String(f.arn)}, nil
+	}
+
+	return &sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::11111:user/jane")}, nil
+}
+
+type fakeOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	accounts map[string]string
+	// number of ListAccounts calls, which happen once per discovery
+	listAccountsCalls int
+	// the parent IDs of accounts and organizational units, missing ones are children of the root
+	parents map[string]string
+	// organizational unit names by ID
+	ous map[string]string
+	// account tags by account ID
+	tags map[string]map[string]string
+}
+
+func (f *fakeOrganizations) ListAccountsWithContext(_ aws.Context, _ *organizations.ListAccountsInput, _ ...request.Option) (*organizations.ListAccountsOutput, error) {
+	f.listAccountsCalls++
+
+	if f.accounts == nil {
+		return nil, awserr.New("AccessDeniedException", "not allowed", nil)
+	}
+
+	out := &organizations.ListAccountsOutput{}
+	for id, name := range f.accounts {
+		out.Accounts = append(out.Accounts, &organizations.Account{Id: aws.String(id), Name: aws.String(name)})
+	}
+
+	return out, nil
+}
+
+func (f *fakeOrganizations) ListParentsWithContext(_ aws.Context, input *organizations.ListParentsInput, _ ...request.Option) (*organizations.ListParentsOutput, error) {
+	parent, ok := f.parents[*input.ChildId]
+	if !ok {
+		return &organizations.ListParentsOutput{Parents: []*organizations.Parent{
+			{Id: aws.String("r-root"), Type: aws.String(organizations.ParentTypeRoot)},
+		}}, nil
+	}
+
+	return &organizations.ListParentsOutput{Parents: []*organizations.Parent{
+		{Id: aws.String(parent), Type: aws.String(organizations.ParentTypeOrganizationalUnit)},
+	}}, nil
+}
+
+func (f *fakeOrganizations) DescribeOrganizationalUnitWithContext(_ aws.Context, input *organizations.DescribeOrganizationalUnitInput, _ ...request.Option) (*organizations.DescribeOrganizationalUnitOutput, error) {
+	return &organizations.DescribeOrganizationalUnitOutput{OrganizationalUnit: &organizations.OrganizationalUnit{
+		Id:   input.OrganizationalUnitId,
+		Name: aws.String(f.ous[*input.OrganizationalUnitId]),
+	}}, nil
+}
+
+func (f *fakeOrganizations) ListTagsForResourceWithContext(_ aws.Context, input *organizations.ListTagsForResourceInput, _ ...request.Option) (*organizations.ListTagsForResourceOutput, error) {
+	out := &organizations.ListTagsForResourceOutput{}
+	for key, value := range f.tags[*input.ResourceId] {
+		out.Tags = append(out.Tags, &organizations.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	return out, nil
+}
+
+type fakeIAM struct {
+	iamiface.IAMAPI
+	groupsErr error
+	// the caller's groups, defaults to admins and developers
+	groups []string
+	// block reading policy versions until the call is cancelled
+	hang bool
+	// inline policy documents by group or role and policy name
+	inline map[string]map[string]*string
+	// attached policy documents by group or role and policy ARN
+	attached map[string]map[string]*string
+	// the serial numbers of the caller's MFA devices
+	mfaDevices []string
+	// the caller's tags
+	userTags map[string]string
+}
+
+func (f *fakeIAM) ListUserTagsWithContext(_ aws.Context, input *iam.ListUserTagsInput, _ ...request.Option) (*iam.ListUserTagsOutput, error) {
+	if aws.StringValue(input.UserName) != "jane" {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such user", nil)
+	}
+
+	out := &iam.ListUserTagsOutput{}
+	for key, value := range f.userTags {
+		out.Tags = append(out.Tags, &iam.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) ListMFADevicesWithContext(_ aws.Context, input *iam.ListMFADevicesInput, _ ...request.Option) (*iam.ListMFADevicesOutput, error) {
+	if aws.StringValue(input.UserName) != "jane" {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "no such user", nil)
+	}
+
+	out := &iam.ListMFADevicesOutput{}
+	for _, serial := range f.mfaDevices {
+		out.MFADevices = append(out.MFADevices, &iam.MFADevice{SerialNumber: aws.String(serial)})
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) ListGroupsForUserWithContext(_ aws.Context, _ *iam.ListGroupsForUserInput, _ ...request.Option) (*iam.ListGroupsForUserOutput, error) {
+	if f.groupsErr != nil {
+		return nil, f.groupsErr
+	}
+
+	groups := f.groups
+	if groups == nil {
+		groups = []string{"admins", "developers"}
+	}
+
+	out := &iam.ListGroupsForUserOutput{}
+	for _, group := range groups {
+		out.Groups = append(out.Groups, &iam.Group{GroupName: aws.String(group)})
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) ListGroupPoliciesWithContext(_ aws.Context, input *iam.ListGroupPoliciesInput, _ ...request.Option) (*iam.ListGroupPoliciesOutput, error) {
+	out := &iam.ListGroupPoliciesOutput{}
+	for name := range f.inline[*input.GroupName] {
+		out.PolicyNames = append(out.PolicyNames, aws.String(name))
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) GetGroupPolicyWithContext(_ aws.Context, input *iam.GetGroupPolicyInput, _ ...request.Option) (*iam.GetGroupPolicyOutput, error) {
+	doc := f.inline[*input.GroupName][*input.PolicyName]
+	if doc == nil {
+		return nil, awserr.New("AccessDenied", "not allowed", nil)
+	}
+
+	return &iam.GetGroupPolicyOutput{PolicyDocument: doc}, nil
+}
+
+func (f *fakeIAM) ListAttachedGroupPoliciesWithContext(_ aws.Context, input *iam.ListAttachedGroupPoliciesInput, _ ...request.Option) (*iam.ListAttachedGroupPoliciesOutput, error) {
+	out := &iam.ListAttachedGroupPoliciesOutput{}
+	for policyArn := range f.attached[*input.GroupName] {
+		out.AttachedPolicies = append(out.AttachedPolicies, &iam.AttachedPolicy{PolicyArn: aws.String(policyArn)})
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) ListRolePoliciesWithContext(_ aws.Context, input *iam.ListRolePoliciesInput, _ ...request.Option) (*iam.ListRolePoliciesOutput, error) {
+	out := &iam.ListRolePoliciesOutput{}
+	for name := range f.inline[*input.RoleName] {
+		out.PolicyNames = append(out.PolicyNames, aws.String(name))
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) GetRolePolicyWithContext(_ aws.Context, input *iam.GetRolePolicyInput, _ ...request.Option) (*iam.GetRolePolicyOutput, error) {
+	doc := f.inline[*input.RoleName][*input.PolicyName]
+	if doc == nil {
+		return nil, awserr.New("AccessDenied", "not allowed", nil)
+	}
+
+	return &iam.GetRolePolicyOutput{PolicyDocument: doc}, nil
+}
+
+func (f *fakeIAM) ListAttachedRolePoliciesWithContext(_ aws.Context, input *iam.ListAttachedRolePoliciesInput, _ ...request.Option) (*iam.ListAttachedRolePoliciesOutput, error) {
+	out := &iam.ListAttachedRolePoliciesOutput{}
+	for policyArn := range f.attached[*input.RoleName] {
+		out.AttachedPolicies = append(out.AttachedPolicies, &iam.AttachedPolicy{PolicyArn: aws.String(policyArn)})
+	}
+
+	return out, nil
+}
+
+func (f *fakeIAM) GetPolicyWithContext(_ aws.Context, input *iam.GetPolicyInput, _ ...request.Option) (*iam.GetPolicyOutput, error) {
+	return &iam.GetPolicyOutput{Policy: &iam.Policy{Arn: input.PolicyArn, DefaultVersionId: aws.String("v1")}}, nil
+}
+
+func (f *fakeIAM) GetPolicyVersionWithContext(ctx aws.Context, input *iam.GetPolicyVersionInput, _ ...request.Option) (*iam.GetPolicyVersionOutput, error) {
+	if f.hang {
+		<-ctx.Done()
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+	}
+
+	for _, policies := range f.attached {
+		if doc, ok := policies[*input.PolicyArn]; ok {
+			return &iam.GetPolicyVersionOutput{PolicyVersion: &iam.PolicyVersion{Document: doc}}, nil
+		}
+	}
+
+	return nil, awserr.New("NoSuchEntity", "no such policy", nil)
+}
+
+func newFakeIAM() *fakeIAM {
+	return &fakeIAM{
+		inline: map[string]map[string]*string{
+			"admins": {
+				"admin-access": policyDocument("arn:aws:iam::12345:role/admin"),
+				// no document, so reading this policy fails
+				"broken": nil,
+			},
+		},
+		attached: map[string]map[string]*string{
+			"developers": {
+				"arn:aws:iam::11111:policy/dev-access": policyDocument("arn:aws:iam::67890:role/developer", "*"),
+			},
+		},
+	}
+}
+
+func TestGetRolesAndAccounts(t *testing.T) {
+	awsContext := NewAWSContext(
+		&fakeOrganizations{accounts: map[string]string{"12345": "my-account", "67890": "other-account"}},
+		newFakeIAM(),
+		&fakeSTS{},
+	)
+
+	inventory, err := awsContext.GetRolesAndAccounts("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	roleArns, accountMap := inventory.RoleArns, inventory.Accounts
+	sort.Strings(roleArns)
+
+	wantRoles := []string{"*", "arn:aws:iam::12345:role/admin", "arn:aws:iam::67890:role/developer"}
+	if !reflect.DeepEqual(roleArns, wantRoles) {
+		t.Errorf("GetRolesAndAccounts() roles = %v, want %v", roleArns, wantRoles)
+	}
+
+	if accountMap["12345"] != "my-account" || len(accountMap) != 2 {
+		t.Errorf("GetRolesAndAccounts() accounts = %v", accountMap)
+	}
+
+	if inventory.SkippedPolicies != 1 {
+		t.Errorf("expected the broken policy to be skipped, skipped %d", inventory.SkippedPolicies)
+	}
+
+	if inventory.CallerArn != "arn:aws:iam::11111:user/jane" {
+		t.Errorf("GetRolesAndAccounts() caller = %s", inventory.CallerArn)
+	}
+
+	wantGrant := Grant{RoleArn: "arn:aws:iam::12345:role/admin", Group: "admins", Policy: "admin-access"}
+	if !containsGrant(inventory.Grants, wantGrant) {
+		t.Errorf("GetRolesAndAccounts() grants = %v, want %v", inventory.Grants, wantGrant)
+	}
+}
+
+func TestGetRolesAndAccountsForAssumedRole(t *testing.T) {
+	fakeIAM := &fakeIAM{
+		// an assumed role has no groups
+		groupsErr: awserr.New(iam.ErrCodeNoSuchEntityException, "no such user", nil),
+		inline: map[string]map[string]*string{
+			"build-agent": {"deploy": policyDocument("arn:aws:iam::12345:role/deployer")},
+		},
+		attached: map[string]map[string]*string{
+			"build-agent": {"arn:aws:iam::11111:policy/read": policyDocument("arn:aws:iam::67890:role/reader")},
+		},
+	}
+
+	awsContext := NewAWSContext(
+		&fakeOrganizations{accounts: map[string]string{"12345": "my-account", "67890": "other-account"}},
+		fakeIAM,
+		&fakeSTS{arn: "arn:aws:sts::11111:assumed-role/build-agent/i-0123456789abcdef0"},
+	)
+
+	inventory, err := awsContext.GetRolesAndAccounts("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	roleArns := inventory.RoleArns
+	sort.Strings(roleArns)
+
+	wantRoles := []string{"arn:aws:iam::12345:role/deployer", "arn:aws:iam::67890:role/reader"}
+	if !reflect.DeepEqual(roleArns, wantRoles) {
+		t.Errorf("GetRolesAndAccounts() roles = %v, want %v", roleArns, wantRoles)
+	}
+
+	wantGrant := Grant{RoleArn: "arn:aws:iam::12345:role/deployer", SourceRole: "build-agent", Policy: "deploy"}
+	if !containsGrant(inventory.Grants, wantGrant) {
+		t.Errorf("GetRolesAndAccounts() grants = %v, want %v", inventory.Grants, wantGrant)
+	}
+
+	if got := wantGrant.String(); got != "role build-agent, policy deploy" {
+		t.Errorf("String() = %s", got)
+	}
+}
+
+func TestGetSessionNameData(t *testing.T) {
+	fakeIAM := newFakeIAM()
+	fakeIAM.userTags = map[string]string{"Email": "jane@example.com", "team": "payments"}
+
+	data, err := NewAWSContext(&fakeOrganizations{}, fakeIAM, &fakeSTS{}).GetSessionNameData(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if data.UserName != "jane" || data.AccountID != "11111" || data.Email != "jane@example.com" ||
+		data.Tags["team"] != "payments" {
+		t.Errorf("GetSessionNameData() = %+v", data)
+	}
+
+	data, err = NewAWSContext(&fakeOrganizations{}, fakeIAM, &fakeSTS{}).GetSessionNameData(false)
+	if err != nil || data.Email != "" || data.Tags != nil {
+		t.Errorf("GetSessionNameData() without tags = %+v, %v", data, err)
+	}
+
+	// assumed role sessions have no tags to look up
+	data, err = NewAWSContext(&fakeOrganizations{}, fakeIAM, &fakeSTS{
+		arn: "arn:aws:sts::11111:assumed-role/build-agent/i-0123456789abcdef0",
+	}).GetSessionNameData(true)
+	if err != nil || data.UserName != "i-0123456789abcdef0" || data.Tags != nil {
+		t.Errorf("GetSessionNameData() of an assumed role = %+v, %v", data, err)
+	}
+}
+
+func containsGrant(grants []Grant, want Grant) bool {
+	for _, grant := range grants {
+		if grant == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestGetRolesAndAccountsWithAccountDetails(t *testing.T) {
+	org := &fakeOrganizations{
+		accounts: map[string]string{"12345": "my-account", "67890": "other-account"},
+		parents:  map[string]string{"12345": "ou-payments", "ou-payments": "ou-workloads"},
+		ous:      map[string]string{"ou-payments": "payments", "ou-workloads": "workloads"},
+		tags:     map[string]map[string]string{"12345": {"team": "checkout"}},
+	}
+
+	inventory, err := NewAWSContext(org, newFakeIAM(), &fakeSTS{}).WithAccountDetails().GetRolesAndAccounts("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := map[string]AccountDetails{
+		"12345": {OUPath: "workloads/payments", Tags: map[string]string{"team": "checkout"}},
+		"67890": {Tags: map[string]string{}},
+	}
+	if !reflect.DeepEqual(inventory.AccountDetails, want) {
+		t.Errorf("GetRolesAndAccounts() account details = %v, want %v", inventory.AccountDetails, want)
+	}
+}
+
+// slowOrganizations takes a while to list the tags of an account and remembers how many were listed at once
+type slowOrganizations struct {
+	*fakeOrganizations
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (f *slowOrganizations) ListTagsForResourceWithContext(ctx aws.Context, input *organizations.ListTagsForResourceInput, opts ...request.Option) (*organizations.ListTagsForResourceOutput, error) {
+	inFlight := atomic.AddInt32(&f.inFlight, 1)
+	defer atomic.AddInt32(&f.inFlight, -1)
+
+	for {
+		maxInFlight := atomic.LoadInt32(&f.maxInFlight)
+		if inFlight <= maxInFlight || atomic.CompareAndSwapInt32(&f.maxInFlight, maxInFlight, inFlight) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	return f.fakeOrganizations.ListTagsForResourceWithContext(ctx, input, opts...)
+}
+
+func TestGetAccountDetailsIsBounded(t *testing.T) {
+	accounts := map[string]string{}
+	for i := 0; i < 4*maxAccountLookups; i++ {
+		accounts[fmt.Sprint(10000+i)] = fmt.Sprint("account-", i)
+	}
+
+	org := &slowOrganizations{fakeOrganizations: &fakeOrganizations{
+		accounts: accounts,
+		parents:  map[string]string{"10000": "ou-payments"},
+		ous:      map[string]string{"ou-payments": "payments"},
+	}}
+
+	inventory, err := NewAWSContext(org, newFakeIAM(), &fakeSTS{}).WithAccountDetails().GetRolesAndAccounts("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(inventory.AccountDetails) != len(accounts) || inventory.AccountDetails["10000"].OUPath != "payments" {
+		t.Errorf("GetRolesAndAccounts() account details = %v", inventory.AccountDetails)
+	}
+
+	if org.maxInFlight < 2 || org.maxInFlight > maxAccountLookups {
+		t.Errorf("expected up to %d concurrent lookups, got %d", maxAccountLookups, org.maxInFlight)
+	}
+}
+
+func TestGetRolesAndAccountsWithoutOrganizationAccess(t *testing.T) {
+	awsContext := NewAWSContext(&fakeOrganizations{}, newFakeIAM(), &fakeSTS{})
+
+	inventory, err := awsContext.GetRolesAndAccounts("some-role")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(inventory.Accounts) != 0 || len(inventory.RoleArns) != 3 {
+		t.Errorf("GetRolesAndAccounts() = %v, %v", inventory.RoleArns, inventory.Accounts)
+	}
+}
+
+func TestGetRolesAndAccountsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		iam  *fakeIAM
+		sts  *fakeSTS
+		want ErrorKind
+	}{
+		{name: "missing credentials",
+			iam:  newFakeIAM(),
+			sts:  &fakeSTS{err: awserr.New("NoCredentialProviders", "no valid providers in chain", nil)},
+			want: KindAuth},
+		{name: "group listing denied",
+			iam:  &fakeIAM{groupsErr: awserr.New("AccessDenied", "not allowed", nil)},
+			sts:  &fakeSTS{},
+			want: KindPermission},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAWSContext(&fakeOrganizations{}, tt.iam, tt.sts).GetRolesAndAccounts("")
+			if got := ErrorKindOf(err); got != tt.want {
+				t.Errorf("GetRolesAndAccounts() error kind = %s, want %s (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestGetRolesAndAccountsCancellation(t *testing.T) {
+	hangingIAM := newFakeIAM()
+	hangingIAM.hang = true
+
+	awsContext := NewAWSContext(&fakeOrganizations{}, hangingIAM, &fakeSTS{})
+
+	t.Run("global timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := awsContext.WithContext(ctx).GetRolesAndAccounts("")
+		if kind := ErrorKindOf(err); kind != KindTimeout {
+			t.Errorf("GetRolesAndAccounts() error kind = %s, want %s (%v)", kind, KindTimeout, err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := awsContext.WithContext(ctx).GetRolesAndAccounts("")
+		if kind := ErrorKindOf(err); kind != KindCanceled {
+			t.Errorf("GetRolesAndAccounts() error kind = %s, want %s (%v)", kind, KindCanceled, err)
+		}
+	})
+
+	t.Run("call timeout", func(t *testing.T) {
+		inventory, err := awsContext.WithCallTimeout(20 * time.Millisecond).GetRolesAndAccounts("")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// the broken inline policy and the attached policy that timed out
+		if inventory.SkippedPolicies != 2 {
+			t.Errorf("expected 2 skipped policies, got %d", inventory.SkippedPolicies)
+		}
+	})
+}
+
+// failingIAM fails to list the inline policies of every group and reports when the hanging policy lookups return
+type failingIAM struct {
+	*fakeIAM
+	returned chan struct{}
+}
+
+func (f *failingIAM) ListGroupPoliciesWithContext(_ aws.Context, _ *iam.ListGroupPoliciesInput, _ ...request.Option) (*iam.ListGroupPoliciesOutput, error) {
+	return nil, awserr.New("AccessDenied", "not allowed", nil)
+}
+
+func (f *failingIAM) GetPolicyVersionWithContext(ctx aws.Context, input *iam.GetPolicyVersionInput, opts ...request.Option) (*iam.GetPolicyVersionOutput, error) {
+	defer func() { f.returned <- struct{}{} }()
+	return f.fakeIAM.GetPolicyVersionWithContext(ctx, input, opts...)
+}
+
+func TestGetRolesAndAccountsCancelsLookupsOnError(t *testing.T) {
+	hangingIAM := newFakeIAM()
+	hangingIAM.hang = true
+
+	failing := &failingIAM{fakeIAM: hangingIAM, returned: make(chan struct{}, 1)}
+
+	_, err := NewAWSContext(&fakeOrganizations{}, failing, &fakeSTS{}).GetRolesAndAccounts("")
+	if kind := ErrorKindOf(err); kind != KindPermission {
+		t.Errorf("GetRolesAndAccounts() error kind = %s, want %s (%v)", kind, KindPermission, err)
+	}
+
+	select {
+	case <-failing.returned:
+	case <-time.After(time.Second):
+		t.Error("the lookup of the attached policy wasn't cancelled")
+	}
+}
+
+type panickingOrganizations struct {
+	*fakeOrganizations
+}
+
+func (f *panickingOrganizations) ListAccountsWithContext(_ aws.Context, _ *organizations.ListAccountsInput, _ ...request.Option) (*organizations.ListAccountsOutput, error) {
+	panic("boom")
+}
+
+func TestGetRolesAndAccountsRecoversAccountLookup(t *testing.T) {
+	org := &panickingOrganizations{&fakeOrganizations{}}
+
+	_, err := NewAWSContext(org, newFakeIAM(), &fakeSTS{}).GetRolesAndAccounts("")
+	if kind := ErrorKindOf(err); kind != KindUnknown {
+		t.Errorf("GetRolesAndAccounts() error kind = %s, want %s (%v)", kind, KindUnknown, err)
+	}
+}
+
+func TestGetGrantsFromPolicy(t *testing.T) {
+	policy := `{"Version":"2012-10-17","Statement":[` +
+		`{"Sid":"AllowAdmin","Effect":"Allow","Action":"sts:AssumeRole","Resource":"arn:aws:iam::12345:role/admin"},` +
+		`{"Effect":"Allow","Action":["sts:AssumeRole"],"Resource":["arn:aws:iam::67890:role/dev"]},` +
+		`{"Sid":"ReadOnly","Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+
+	grants, err := getGrantsFromPolicy(aws.String(url.QueryEscape(policy)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []Grant{
+		{RoleArn: "arn:aws:iam::12345:role/admin", Sid: "AllowAdmin"},
+		{RoleArn: "arn:aws:iam::67890:role/dev"},
+	}
+	if !reflect.DeepEqual(grants, want) {
+		t.Errorf("getGrantsFromPolicy() = %v, want %v", grants, want)
+	}
+}
+
+func TestGetMFASerial(t *testing.T) {
+	tests := []struct {
+		name    string
+		arn     string
+		devices []string
+		want    string
+	}{
+		{name: "no device", want: ""},
+		{
+			name:    "user with a path",
+			arn:     "arn:aws:iam::11111:user/engineering/jane",
+			devices: []string{"arn:aws:iam::11111:mfa/jane"},
+			want:    "arn:aws:iam::11111:mfa/jane",
+		},
+		{
+			name:    "assumed role",
+			arn:     "arn:aws:sts::11111:assumed-role/build-agent/i-0123456789abcdef0",
+			devices: []string{"arn:aws:iam::11111:mfa/jane"},
+			want:    "",
+		},
+		{name: "one device", devices: []string{"arn:aws:iam::11111:mfa/jane"}, want: "arn:aws:iam::11111:mfa/jane"},
+		{
+			name:    "several devices",
+			devices: []string{"arn:aws:iam::11111:mfa/jane-phone", "arn:aws:iam::11111:mfa/jane-key"},
+			want:    "arn:aws:iam::11111:mfa/jane-phone",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeIAM := newFakeIAM()
+			fakeIAM.mfaDevices = tt.devices
+
+			got, err := NewAWSContext(&fakeOrganizations{}, fakeIAM, &fakeSTS{arn: tt.arn}).GetMFASerial()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("GetMFASerial() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err := NewAWSContext(&fakeOrganizations{}, newFakeIAM(), &fakeSTS{err: errors.New("no credentials")}).GetMFASerial()
+	if err == nil {
+		t.Error("expected an error without caller identity")
+	}
+}
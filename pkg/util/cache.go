@@ -0,0 +1,184 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+)
+
+// Cache stores discovered inventories on disk, keyed by the caller's identity, the region and the custom endpoints
+type Cache struct {
+	// The directory the cache files are stored in
+	Dir string
+	// How long a cached inventory may be used
+	TTL time.Duration
+	// Ignore cached inventories, but still update the cache
+	Refresh bool
+}
+
+type cacheEntry struct {
+	// the caller's groups at the time of discovery, a change in membership invalidates the entry
+	Groups    []string   `json:"groups"`
+	Inventory *Inventory `json:"inventory"`
+}
+
+// NewCache creates a cache in the user's cache directory
+func NewCache(ttl time.Duration, refresh bool) (*Cache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, IOError(err, "could not find cache directory")
+	}
+
+	return &Cache{
+		Dir:     filepath.Join(dir, "aws-cfg-generator"),
+		TTL:     ttl,
+		Refresh: refresh,
+	}, nil
+}
+
+func (c *Cache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// cacheKey identifies the inventories of a caller discovered with the same region and custom endpoints, e.g. so that
+// the inventory of a local stand-in for AWS is never used for the real one
+func (ctx *AWSContext) cacheKey(caller *caller) string {
+	key := []string{caller.arn, ctx.region}
+
+	for id, endpoint := range ctx.endpoints {
+		if endpoint != "" {
+			key = append(key, id+"="+endpoint)
+		}
+	}
+
+	// the caller and region stay in front
+	sort.Strings(key[2:])
+
+	return strings.Join(key, "\n")
+}
+
+func groupNames(caller *caller) []string {
+	names := make([]string, 0, len(caller.groups))
+
+	for _, group := range caller.groups {
+		names = append(names, *group.GroupName)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// load returns the cached inventory of caller, or nil if there is no valid one
+func (c *Cache) load(key string, caller *caller) *Inventory {
+	if c.Refresh {
+		return nil
+	}
+
+	content, err := os.ReadFile(c.path(key))
+	if err != nil {
+		log.Debug().Err(err).Msg("no cached inventory found")
+		return nil
+	}
+
+	var entry cacheEntry
+
+	err = json.Unmarshal(content, &entry)
+	if err != nil || entry.Inventory == nil || entry.Inventory.Version != InventoryVersion {
+		log.Debug().Err(err).Msg("ignoring unreadable cached inventory")
+		return nil
+	}
+
+	if age := time.Since(entry.Inventory.GeneratedAt); age > c.TTL {
+		log.Debug().Dur("age", age).Msg("cached inventory expired")
+		return nil
+	}
+
+	if !slices.Equal(entry.Groups, groupNames(caller)) {
+		log.Debug().Strs("cached-groups", entry.Groups).Msg("group membership changed since the inventory was cached")
+		return nil
+	}
+
+	log.Warn().Time("generated-at", entry.Inventory.GeneratedAt).
+		Msg("Using cached inventory, run with --refresh to discover roles and accounts again")
+
+	return entry.Inventory
+}
+
+func (c *Cache) save(key string, caller *caller, inventory *Inventory) error {
+	content, err := json.Marshal(cacheEntry{Groups: groupNames(caller), Inventory: inventory})
+	if err != nil {
+		return newError(KindUnknown, err, "could not marshal inventory")
+	}
+
+	err = os.MkdirAll(c.Dir, 0o700)
+	if err != nil {
+		return IOError(err, "could not create cache directory %s", c.Dir)
+	}
+
+	err = os.WriteFile(c.path(key), content, 0o600)
+	if err != nil {
+		return IOError(err, "could not write cache file %s", c.path(key))
+	}
+
+	return nil
+}
+
+// GetCachedRolesAndAccounts works like GetRolesAndAccounts, but only runs a full discovery if the cache has no
+// inventory for the caller, region and endpoints that is younger than the TTL and was discovered with the same group
+// memberships. Inventories with policies that could not be read aren't cached, so that a temporary failure doesn't
+// outlive the run.
+func (ctx *AWSContext) GetCachedRolesAndAccounts(cache *Cache, role string) (*Inventory, error) {
+	caller, err := ctx.getCaller()
+	if err != nil {
+		return nil, err
+	}
+
+	key := ctx.cacheKey(caller)
+	inventory := cache.load(key, caller)
+
+	if inventory != nil && ctx.accountDetails && inventory.AccountDetails == nil {
+		log.Debug().Msg("cached inventory lacks account details")
+		inventory = nil
+	}
+
+	if inventory == nil {
+		inventory, err = ctx.discover(caller)
+		if err != nil {
+			return nil, err
+		}
+
+		if inventory.SkippedPolicies > 0 {
+			log.Debug().Msg("not caching an incomplete inventory")
+		} else if err := cache.save(key, caller, inventory); err != nil {
+			// a broken cache should never prevent generating a config
+			log.Warn().Err(err).Msg("could not cache inventory")
+		}
+	}
+
+	inventory.AddOrgRole(role)
+
+	return inventory, nil
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetCachedRolesAndAccounts(t *testing.T) {
+	fakeIAM := newFakeIAM()
+	// inventories with skipped policies aren't cached, see TestGetCachedRolesAndAccountsSkipsPartialInventories
+	delete(fakeIAM.inline["admins"], "broken")
+
+	fakeOrg := &fakeOrganizations{accounts: map[string]string{"12345": "my-account"}}
+	awsContext := NewAWSContext(fakeOrg, fakeIAM, &fakeSTS{})
+	cache := &Cache{Dir: t.TempDir(), TTL: time.Hour}
+
+	discover := func(wantDiscoveries int) {
+		t.Helper()
+
+		inventory, err := awsContext.GetCachedRolesAndAccounts(cache, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if len(inventory.RoleArns) != 3 {
+			t.Errorf("GetCachedRolesAndAccounts() roles = %v", inventory.RoleArns)
+		}
+
+		if fakeOrg.listAccountsCalls != wantDiscoveries {
+			t.Errorf("expected %d full discoveries, got %d", wantDiscoveries, fakeOrg.listAccountsCalls)
+		}
+	}
+
+	discover(1)
+	// cache hit
+	discover(1)
+
+	cache.Refresh = true
+	discover(2)
+	cache.Refresh = false
+
+	// a change in group membership invalidates the cache
+	fakeIAM.groups = []string{"developers", "admins", "auditors"}
+	discover(3)
+	discover(3)
+
+	// so do a different region or custom endpoints
+	awsContext.region = "us-east-1"
+	discover(4)
+	discover(4)
+
+	awsContext.endpoints = map[string]string{"iam": "http://localhost:4566"}
+	discover(5)
+	discover(5)
+
+	awsContext.endpoints = map[string]string{"iam": "http://localhost:4566", "sts": "http://localhost:4566"}
+	discover(6)
+	discover(6)
+
+	cache.TTL = 0
+	discover(7)
+}
+
+func TestGetCachedRolesAndAccountsSkipsPartialInventories(t *testing.T) {
+	fakeOrg := &fakeOrganizations{accounts: map[string]string{"12345": "my-account"}}
+	awsContext := NewAWSContext(fakeOrg, newFakeIAM(), &fakeSTS{})
+	cache := &Cache{Dir: t.TempDir(), TTL: time.Hour}
+
+	for i := 1; i <= 2; i++ {
+		// a fresh context per run like generator.Discover, as skipped policies are counted per context
+		inventory, err := awsContext.WithContext(context.Background()).GetCachedRolesAndAccounts(cache, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if inventory.SkippedPolicies != 1 {
+			t.Errorf("expected the broken policy to be skipped, skipped %d", inventory.SkippedPolicies)
+		}
+
+		if fakeOrg.listAccountsCalls != i {
+			t.Errorf("expected %d full discoveries, got %d", i, fakeOrg.listAccountsCalls)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"gopkg.in/ini.v1"
+)
+
+// ConfigChanges summarizes how the sections of an INI config changed
+type ConfigChanges struct {
+	Added   []string        `json:"added"`
+	Removed []string        `json:"removed"`
+	Changed []SectionChange `json:"changed"`
+}
+
+// SectionChange lists the keys of a section that were added, removed or set to a different value
+type SectionChange struct {
+	Section     string   `json:"section"`
+	AddedKeys   []string `json:"added_keys"`
+	RemovedKeys []string `json:"removed_keys"`
+	ChangedKeys []string `json:"changed_keys"`
+}
+
+// Empty reports whether nothing changed
+func (c *ConfigChanges) Empty() bool {
+	return len(c.Added)+len(c.Removed)+len(c.Changed) == 0
+}
+
+// CompareConfigs returns the sections and keys that differ between two INI configs, comments are ignored
+func CompareConfigs(oldContent, newContent []byte) (*ConfigChanges, error) {
+	oldConfig, err := ini.Load(oldContent)
+	if err != nil {
+		return nil, ConfigError(err, "could not parse the current config")
+	}
+
+	newConfig, err := ini.Load(newContent)
+	if err != nil {
+		return nil, ConfigError(err, "could not parse the new config")
+	}
+
+	changes := &ConfigChanges{Added: []string{}, Removed: []string{}, Changed: []SectionChange{}}
+
+	for _, section := range newConfig.Sections() {
+		name := section.Name()
+		if name == ini.DefaultSection && len(section.Keys()) == 0 {
+			continue
+		}
+
+		oldSection, err := oldConfig.GetSection(name)
+		if err != nil {
+			changes.Added = append(changes.Added, name)
+			continue
+		}
+
+		if change, ok := compareSections(oldSection, section); ok {
+			changes.Changed = append(changes.Changed, change)
+		}
+	}
+
+	for _, section := range oldConfig.Sections() {
+		name := section.Name()
+		if name == ini.DefaultSection && len(section.Keys()) == 0 {
+			continue
+		}
+
+		if _, err := newConfig.GetSection(name); err != nil {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+
+	return changes, nil
+}
+
+func compareSections(oldSection, newSection *ini.Section) (SectionChange, bool) {
+	change := SectionChange{
+		Section:     newSection.Name(),
+		AddedKeys:   []string{},
+		RemovedKeys: []string{},
+		ChangedKeys: []string{},
+	}
+
+	for _, key := range newSection.Keys() {
+		switch {
+		case !oldSection.HasKey(key.Name()):
+			change.AddedKeys = append(change.AddedKeys, key.Name())
+		case oldSection.Key(key.Name()).Value() != key.Value():
+			change.ChangedKeys = append(change.ChangedKeys, key.Name())
+		}
+	}
+
+	for _, key := range oldSection.Keys() {
+		if !newSection.HasKey(key.Name()) {
+			change.RemovedKeys = append(change.RemovedKeys, key.Name())
+		}
+	}
+
+	changed := len(change.AddedKeys)+len(change.RemovedKeys)+len(change.ChangedKeys) > 0
+
+	return change, changed
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareConfigs(t *testing.T) {
+	oldContent := []byte(`[default]
+region = eu-central-1
+
+[profile kept]
+role_arn = arn:aws:iam::12345:role/admin
+
+[profile changed]
+role_arn = arn:aws:iam::12345:role/admin
+region   = eu-west-1
+output   = json
+
+[profile removed]
+role_arn = arn:aws:iam::67890:role/admin
+`)
+	newContent := []byte(`[default]
+region = eu-central-1
+
+# a comment doesn't change a profile
+[profile kept]
+role_arn = arn:aws:iam::12345:role/admin
+
+[profile changed]
+role_arn   = arn:aws:iam::12345:role/developer
+output     = json
+mfa_serial = arn:aws:iam::11111:mfa/jane
+
+[profile added]
+role_arn = arn:aws:iam::67890:role/admin
+`)
+
+	changes, err := CompareConfigs(oldContent, newContent)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := &ConfigChanges{
+		Added:   []string{"profile added"},
+		Removed: []string{"profile removed"},
+		Changed: []SectionChange{{
+			Section:     "profile changed",
+			AddedKeys:   []string{"mfa_serial"},
+			RemovedKeys: []string{"region"},
+			ChangedKeys: []string{"role_arn"},
+		}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("CompareConfigs() = %+v, want %+v", changes, want)
+	}
+
+	changes, err = CompareConfigs(newContent, newContent)
+	if err != nil || !changes.Empty() {
+		t.Errorf("CompareConfigs() of the same config = %+v, %v", changes, err)
+	}
+}
@@ -0,0 +1,162 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CollisionStrategy decides how profiles for different roles that end up with the same name are told apart
+type CollisionStrategy string
+
+const (
+	// CollisionAppendRoleName appends the role name to colliding profiles, and the account ID if that is not enough
+	CollisionAppendRoleName CollisionStrategy = "append-role-name"
+	// CollisionAppendAccountID appends the account ID to colliding profiles, and the role name if that is not enough
+	CollisionAppendAccountID CollisionStrategy = "append-account-id"
+	// CollisionFail aborts generation if any profile names collide
+	CollisionFail CollisionStrategy = "fail"
+)
+
+type profileSuffix func(profile Profile) string
+
+func roleNameSuffix(profile Profile) string  { return profile.RoleName }
+func accountIDSuffix(profile Profile) string { return profile.AccountID }
+
+// suffixes returns the suffixes to try in order of preference
+func (s CollisionStrategy) suffixes() ([]profileSuffix, error) {
+	switch s {
+	case CollisionAppendRoleName, "":
+		return []profileSuffix{roleNameSuffix, accountIDSuffix}, nil
+	case CollisionAppendAccountID:
+		return []profileSuffix{accountIDSuffix, roleNameSuffix}, nil
+	case CollisionFail:
+		return nil, nil
+	default:
+		return nil, ConfigError(nil, "unknown collision strategy %q", s)
+	}
+}
+
+// collisions returns the indexes of the profiles sharing a name, in order of their first occurrence
+func collisions(profiles []Profile) [][]int {
+	byName := map[string][]int{}
+
+	var names []string
+
+	for i, profile := range profiles {
+		if _, ok := byName[profile.ProfileName]; !ok {
+			names = append(names, profile.ProfileName)
+		}
+
+		byName[profile.ProfileName] = append(byName[profile.ProfileName], i)
+	}
+
+	var result [][]int
+
+	for _, name := range names {
+		if len(byName[name]) > 1 {
+			result = append(result, byName[name])
+		}
+	}
+
+	return result
+}
+
+func collisionError(profiles []Profile, groups [][]int) error {
+	reports := make([]string, 0, len(groups))
+
+	for _, group := range groups {
+		roleArns := make([]string, 0, len(group))
+		for _, i := range group {
+			roleArns = append(roleArns, profiles[i].RoleArn)
+		}
+
+		reports = append(reports, fmt.Sprintf("%s (%s)", profiles[group[0]].ProfileName, strings.Join(roleArns, ", ")))
+	}
+
+	return ConfigError(nil, "profile names are used by several roles: %s", strings.Join(reports, "; "))
+}
+
+// distinguishes reports whether suffix tells all profiles of a group apart
+func distinguishes(profiles []Profile, group []int, suffix profileSuffix) bool {
+	seen := map[string]bool{}
+
+	for _, i := range group {
+		if seen[suffix(profiles[i])] {
+			return false
+		}
+
+		seen[suffix(profiles[i])] = true
+	}
+
+	return true
+}
+
+// ResolveCollisions renames profiles of different roles that share a name, so that no role silently replaces another
+// one in the generated config. Every collision is logged along with the names it was resolved to.
+func ResolveCollisions(profiles []Profile, strategy CollisionStrategy) ([]Profile, error) {
+	suffixes, err := strategy.suffixes()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := collisions(profiles)
+	if len(groups) == 0 {
+		return profiles, nil
+	}
+
+	if strategy == CollisionFail {
+		return nil, collisionError(profiles, groups)
+	}
+
+	for _, group := range groups {
+		name := profiles[group[0]].ProfileName
+		roleArns := make([]string, 0, len(group))
+		resolved := make([]string, 0, len(group))
+
+		// use the first suffix that tells the profiles apart, or all of them if none does on its own
+		applicable := suffixes
+		for _, suffix := range suffixes {
+			if distinguishes(profiles, group, suffix) {
+				applicable = []profileSuffix{suffix}
+				break
+			}
+		}
+
+		for _, i := range group {
+			for _, suffix := range applicable {
+				profiles[i].ProfileName = fmt.Sprint(profiles[i].ProfileName, "_", suffix(profiles[i]))
+			}
+
+			roleArns = append(roleArns, profiles[i].RoleArn)
+			resolved = append(resolved, profiles[i].ProfileName)
+		}
+
+		log.Warn().
+			Str("profile", name).
+			Strs("roles", roleArns).
+			Strs("resolved", resolved).
+			Msg("profile name is used by several roles")
+	}
+
+	// a resolved name may still clash with the name of another profile
+	if groups := collisions(profiles); len(groups) > 0 {
+		return nil, collisionError(profiles, groups)
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func profileNames(profiles []Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.ProfileName)
+	}
+
+	return names
+}
+
+func TestGetProfilesCollisions(t *testing.T) {
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "payments", "67890": "payments", "11111": "shared"},
+		RoleArns: []string{
+			"arn:aws:iam::12345:role/admin",
+			"arn:aws:iam::12345:role/admin",
+			"arn:aws:iam::12345:role/developer",
+			"arn:aws:iam::67890:role/admin",
+			"arn:aws:iam::11111:role/admin",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		strategy CollisionStrategy
+		want     []string
+		wantErr  bool
+	}{
+		{name: "append role name",
+			strategy: CollisionAppendRoleName,
+			// the role name alone does not tell the admin roles of both payments accounts apart
+			want: []string{"payments_admin_12345", "payments_developer_12345", "payments_admin_67890", "shared"}},
+		{name: "append account id",
+			strategy: CollisionAppendAccountID,
+			want:     []string{"payments_12345_admin", "payments_12345_developer", "payments_67890_admin", "shared"}},
+		{name: "default",
+			want: []string{"payments_admin_12345", "payments_developer_12345", "payments_admin_67890", "shared"}},
+		{name: "fail",
+			strategy: CollisionFail,
+			wantErr:  true},
+		{name: "unknown strategy",
+			strategy: "rename",
+			wantErr:  true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles, err := GetProfiles("", inventory, ProfileOptions{Collisions: tt.strategy})
+			if tt.wantErr {
+				if ErrorKindOf(err) != KindConfig {
+					t.Errorf("GetProfiles() error = %v, want a config error", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := profileNames(profiles); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetProfiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCollisionsWithExistingName(t *testing.T) {
+	profiles := []Profile{
+		{RoleArn: "arn:aws:iam::12345:role/admin", RoleName: "admin", AccountID: "12345", ProfileName: "payments"},
+		{RoleArn: "arn:aws:iam::12345:role/dev", RoleName: "dev", AccountID: "12345", ProfileName: "payments"},
+		{RoleArn: "arn:aws:iam::67890:role/admin", RoleName: "admin", AccountID: "67890", ProfileName: "payments_dev"},
+	}
+
+	if _, err := ResolveCollisions(profiles, CollisionAppendRoleName); err == nil {
+		t.Errorf("expected an error for a resolved name that clashes with another profile")
+	}
+}
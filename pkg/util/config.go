@@ -0,0 +1,86 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+)
+
+// Config holds the rules that shape the generated profiles, usually maintained by a team in a shared file
+type Config struct {
+	NameRules *NameRules `json:"name_rules"`
+	Filters   *Filters   `json:"filters"`
+	// Replace the default stages poc, dev, int, stg, prd and global
+	Stages []Stage `json:"stages"`
+	// If set, a stage suffix only counts if it is preceded by one of these, e.g. "-"
+	StageSeparators []string `json:"stage_separators"`
+	// Additional keys of the aws-vault profiles, e.g. a shorter duration_seconds for production
+	ProfileKeys ProfileKeyRules `json:"profile_keys"`
+}
+
+// StageModel returns the configured stages, or nil if the config doesn't change the default ones
+func (c *Config) StageModel() (*StageModel, error) {
+	if len(c.Stages) == 0 && len(c.StageSeparators) == 0 {
+		return nil, nil
+	}
+
+	stages := c.Stages
+	if len(stages) == 0 {
+		stages = defaultStages()
+	}
+
+	return NewStageModel(stages, c.StageSeparators)
+}
+
+// ReadConfig loads a JSON config file, rejecting unknown keys so that typos don't go unnoticed
+func ReadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, IOError(err, "could not read config %s", path)
+	}
+
+	var config Config
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&config)
+	if err != nil {
+		return nil, ConfigError(err, "could not parse config %s", path)
+	}
+
+	if config.NameRules != nil {
+		if err := config.NameRules.Compile(); err != nil {
+			return nil, ConfigError(err, "invalid name rules in %s", path)
+		}
+	}
+
+	if config.Filters != nil {
+		if err := config.Filters.Compile(); err != nil {
+			return nil, ConfigError(err, "invalid filters in %s", path)
+		}
+	}
+
+	if err := config.ProfileKeys.Compile(); err != nil {
+		return nil, ConfigError(err, "invalid profile keys in %s", path)
+	}
+
+	if _, err := config.StageModel(); err != nil {
+		return nil, ConfigError(err, "invalid stages in %s", path)
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,290 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the number of unchanged lines shown around every change
+const diffContext = 3
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+type diffOp struct {
+	kind byte // ' ' for unchanged, '-' for removed and '+' for added lines
+	line string
+	// the number of old and new lines before this one
+	oldIndex int
+	newIndex int
+}
+
+// UnifiedDiff returns the changes from oldContent to newContent in the unified diff format, colored with ANSI escape
+// codes if color is set, or an empty string if there are none
+func UnifiedDiff(oldName, newName string, oldContent, newContent []byte, color bool) string {
+	ops := diffLines(splitLines(string(oldContent)), splitLines(string(newContent)))
+
+	paint := func(code, text string) string {
+		if !color {
+			return text
+		}
+
+		return code + text + colorReset
+	}
+
+	var diff strings.Builder
+
+	for _, hunk := range diffHunks(ops) {
+		if diff.Len() == 0 {
+			diff.WriteString(paint(colorBold, "--- "+oldName) + "\n")
+			diff.WriteString(paint(colorBold, "+++ "+newName) + "\n")
+		}
+
+		diff.WriteString(paint(colorCyan, hunkHeader(hunk)) + "\n")
+
+		for _, op := range hunk {
+			switch op.kind {
+			case '-':
+				diff.WriteString(paint(colorRed, "-"+op.line) + "\n")
+			case '+':
+				diff.WriteString(paint(colorGreen, "+"+op.line) + "\n")
+			default:
+				diff.WriteString(" " + op.line + "\n")
+			}
+		}
+	}
+
+	return diff.String()
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines finds the shortest edit script turning a into b with the algorithm of Eugene W. Myers
+func diffLines(a, b []string) []diffOp {
+	ops := appendDiff(nil, a, b)
+
+	oldIndex, newIndex := 0, 0
+	for i := range ops {
+		ops[i].oldIndex, ops[i].newIndex = oldIndex, newIndex
+
+		if ops[i].kind != '+' {
+			oldIndex++
+		}
+
+		if ops[i].kind != '-' {
+			newIndex++
+		}
+	}
+
+	return ops
+}
+
+// appendDiff appends the edit script turning a into b to ops. Both are split at the middle snake of a shortest edit
+// script and the halves are diffed recursively, so that only linear space is needed even if every line changed.
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, diffOp{kind: ' ', line: a[0]})
+		a, b = a[1:], b[1:]
+	}
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if x, y, ok := middleSnake(a, b); ok {
+		ops = appendDiff(ops, a[:x], b[:y])
+		ops = appendDiff(ops, a[x:], b[y:])
+	} else {
+		for _, line := range a {
+			ops = append(ops, diffOp{kind: '-', line: line})
+		}
+
+		for _, line := range b {
+			ops = append(ops, diffOp{kind: '+', line: line})
+		}
+	}
+
+	for _, line := range common {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+
+	return ops
+}
+
+// middleSnake searches a shortest edit script from both ends at once and returns where the searches meet. It fails if
+// a or b is empty, as the edit script is obvious then.
+func middleSnake(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	delta := n - m
+	// an odd delta means the forward search meets the backward one, an even one the other way round
+	front := delta%2 != 0
+
+	// the furthest reaching x of every diagonal k = x - y, from the start and from the end
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	// diagonals leaving the grid are skipped from then on
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			forward[offset+k] = x
+
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > m:
+				forwardStart += 2
+			case front:
+				if i := offset + delta - k; i >= 0 && i < len(backward) && backward[i] != -1 && x >= n-backward[i] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+
+			backward[offset+k] = x
+
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > m:
+				backwardStart += 2
+			case !front:
+				if i := offset + delta - k; i >= 0 && i < len(forward) && forward[i] != -1 && forward[i] >= n-x {
+					return forward[i], forward[i] - (i - offset), true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// diffHunks groups the changes with their context, merging changes whose context overlaps
+func diffHunks(ops []diffOp) [][]diffOp {
+	var hunks [][]diffOp
+
+	start, end := -1, -1
+
+	for i, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+
+		if start >= 0 && i-diffContext > end {
+			hunks = append(hunks, ops[start:end])
+			start = -1
+		}
+
+		if start < 0 {
+			start = i - diffContext
+			if start < 0 {
+				start = 0
+			}
+		}
+
+		end = i + diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+	}
+
+	if start >= 0 {
+		hunks = append(hunks, ops[start:end])
+	}
+
+	return hunks
+}
+
+func hunkHeader(hunk []diffOp) string {
+	oldStart, newStart := hunk[0].oldIndex, hunk[0].newIndex
+	oldCount, newCount := 0, 0
+
+	for _, op := range hunk {
+		if op.kind != '+' {
+			oldCount++
+		}
+
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+
+	// an empty range starts at the line before it
+	if oldCount > 0 {
+		oldStart++
+	}
+
+	if newCount > 0 {
+		newStart++
+	}
+
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)
+}
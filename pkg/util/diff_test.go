@@ -0,0 +1,148 @@
+package util
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(lines ...string) []byte {
+		return []byte(strings.Join(lines, "\n") + "\n")
+	}
+
+	tests := []struct {
+		name       string
+		oldContent []byte
+		newContent []byte
+		want       string
+	}{
+		{
+			name:       "no changes",
+			oldContent: lines("a", "b"),
+			newContent: lines("a", "b"),
+			want:       "",
+		},
+		{
+			name:       "new file",
+			newContent: lines("a", "b"),
+			want:       "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:       "changed line with context",
+			oldContent: lines("1", "2", "3", "4", "5", "6", "7", "8", "9"),
+			newContent: lines("1", "2", "3", "4", "five", "6", "7", "8", "9"),
+			want:       "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:       "separate hunks",
+			oldContent: lines("1", "2", "3", "4", "5", "6", "7", "8", "9", "10"),
+			newContent: lines("2", "3", "4", "5", "6", "7", "8", "9", "10", "11"),
+			want: "--- old\n+++ new\n@@ -1,4 +1,3 @@\n-1\n 2\n 3\n 4\n" +
+				"@@ -8,3 +7,4 @@\n 8\n 9\n 10\n+11\n",
+		},
+		{
+			name:       "removed file",
+			oldContent: lines("a"),
+			want:       "--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("old", "new", tt.oldContent, tt.newContent, false); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffColor(t *testing.T) {
+	got := UnifiedDiff("old", "new", []byte("a\n"), []byte("b\n"), true)
+
+	for _, want := range []string{colorRed + "-a" + colorReset, colorGreen + "+b" + colorReset, colorCyan + "@@"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("UnifiedDiff() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	// the length of the longest common subsequence, so that a shortest edit script has len(a)+len(b)-2*lcs edits
+	lcs := func(a, b []string) int {
+		lengths := make([][]int, len(a)+1)
+		for i := range lengths {
+			lengths[i] = make([]int, len(b)+1)
+		}
+
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case a[i] == b[j]:
+					lengths[i][j] = lengths[i+1][j+1] + 1
+				case lengths[i+1][j] > lengths[i][j+1]:
+					lengths[i][j] = lengths[i+1][j]
+				default:
+					lengths[i][j] = lengths[i][j+1]
+				}
+			}
+		}
+
+		return lengths[0][0]
+	}
+
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		ops := diffLines(a, b)
+
+		var oldLines, newLines []string
+
+		edits := 0
+
+		for _, op := range ops {
+			if op.kind != '+' {
+				oldLines = append(oldLines, op.line)
+			}
+
+			if op.kind != '-' {
+				newLines = append(newLines, op.line)
+			}
+
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+
+		if !slices.Equal(oldLines, a) || !slices.Equal(newLines, b) {
+			t.Fatalf("diffLines(%q, %q) = %v doesn't turn one into the other", a, b, ops)
+		}
+
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Fatalf("diffLines(%q, %q) has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestDiffLinesRewrittenFile(t *testing.T) {
+	a, b := make([]string, 2000), make([]string, 2000)
+	for i := range a {
+		a[i], b[i] = fmt.Sprint("old ", i), fmt.Sprint("new ", i)
+	}
+
+	if ops := diffLines(a, b); len(ops) != len(a)+len(b) {
+		t.Errorf("expected %d edits, got %d", len(a)+len(b), len(ops))
+	}
+}
@@ -0,0 +1,171 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// ErrorKind classifies a failure so that the CLI can exit with a meaningful code
+type ErrorKind int
+
+const (
+	KindUnknown ErrorKind = iota
+	KindAuth
+	KindPermission
+	KindConfig
+	KindIO
+	KindTimeout
+	KindCanceled
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindPermission:
+		return "permission"
+	case KindConfig:
+		return "config"
+	case KindIO:
+		return "io"
+	case KindTimeout:
+		return "timeout"
+	case KindCanceled:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+// ExitCode is the process exit code used for errors of this kind
+func (k ErrorKind) ExitCode() int {
+	switch k {
+	case KindAuth:
+		return 3
+	case KindPermission:
+		return 4
+	case KindConfig:
+		return 5
+	case KindIO:
+		return 6
+	case KindTimeout:
+		return 7
+	case KindCanceled:
+		// the conventional exit code of a process interrupted by SIGINT
+		return 130
+	default:
+		return 1
+	}
+}
+
+type Error struct {
+	Kind ErrorKind
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+
+	return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(kind ErrorKind, err error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Err: err}
+}
+
+func ConfigError(err error, format string, args ...interface{}) error {
+	return newError(KindConfig, err, format, args...)
+}
+
+func IOError(err error, format string, args ...interface{}) error {
+	return newError(KindIO, err, format, args...)
+}
+
+// ErrorKindOf returns the kind of the first *Error in err's chain
+func ErrorKindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return KindUnknown
+}
+
+// ContextError wraps the error of a done context, distinguishing timeouts from cancellation
+func ContextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newError(KindTimeout, err, "timed out")
+	}
+
+	return newError(KindCanceled, err, "canceled")
+}
+
+// ExitCode maps err to the exit code of its kind, 0 for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	return ErrorKindOf(err).ExitCode()
+}
+
+var (
+	authErrorCodes = map[string]bool{
+		"NoCredentialProviders":       true,
+		"ExpiredToken":                true,
+		"ExpiredTokenException":       true,
+		"InvalidClientTokenId":        true,
+		"SignatureDoesNotMatch":       true,
+		"UnrecognizedClientException": true,
+	}
+	permissionErrorCodes = map[string]bool{
+		"AccessDenied":                       true,
+		"AccessDeniedException":              true,
+		"AccessDeniedForDependencyException": true,
+		"UnauthorizedOperation":              true,
+		"AWSOrganizationsNotInUseException":  true,
+	}
+)
+
+// awsError wraps an error returned by the AWS SDK, classifying it by its error code
+func awsError(err error, format string, args ...interface{}) error {
+	kind := KindUnknown
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch {
+		case aerr.Code() == request.CanceledErrorCode:
+			kind = ErrorKindOf(ContextError(aerr.OrigErr()))
+		case authErrorCodes[aerr.Code()]:
+			kind = KindAuth
+		case permissionErrorCodes[aerr.Code()]:
+			kind = KindPermission
+		}
+	}
+
+	return newError(kind, err, format, args...)
+}
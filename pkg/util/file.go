@@ -0,0 +1,135 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how often a held lock is tried again
+const lockRetryInterval = 100 * time.Millisecond
+
+// errLocked is returned by tryLock if another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// resolvePath follows symlinks, so that e.g. a config linked from a dotfiles repository is replaced instead of the link
+func resolvePath(path string) string {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+
+	return target
+}
+
+// LockFile takes an advisory lock on path, held by a lock file next to it, waiting up to timeout for other processes
+// to release it. The lock is released by calling unlock, or when the process exits.
+func LockFile(path string, timeout time.Duration) (unlock func(), err error) {
+	lockPath := resolvePath(path) + ".lock"
+	deadline := time.Now().Add(timeout)
+	waiting := false
+
+	for {
+		release, err := tryLock(lockPath)
+		if err == nil {
+			unlock = func() {
+				if err := release(); err != nil {
+					log.Warn().Err(err).Str("file-path", lockPath).Msg("could not release lock")
+				}
+			}
+
+			return unlock, nil
+		}
+
+		if !errors.Is(err, errLocked) {
+			return nil, IOError(err, "could not lock %s", path)
+		}
+
+		if time.Now().After(deadline) {
+			return nil, newError(KindTimeout, err, "timed out waiting for the lock on %s", path)
+		}
+
+		if !waiting {
+			log.Info().Str("file-path", lockPath).Msg("Waiting for another process to release the lock")
+			waiting = true
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// WriteFileAtomic replaces the file at path with content by renaming a temporary file over it, so that the file is
+// never seen half-written. The mode and owner of an existing file are preserved, new files get perm.
+func WriteFileAtomic(path string, content []byte, perm fs.FileMode) (err error) {
+	path = resolvePath(path)
+
+	info, statErr := os.Stat(path)
+	if statErr != nil && !errors.Is(statErr, fs.ErrNotExist) {
+		return IOError(statErr, "could not read %s", path)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return IOError(err, "could not create a temporary file for %s", path)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	mode := perm
+	if statErr == nil {
+		mode = info.Mode().Perm()
+
+		if err := preserveOwner(tmp, info); err != nil {
+			return IOError(err, "could not preserve the owner of %s", path)
+		}
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		return IOError(err, "could not set the mode of %s", path)
+	}
+
+	if _, err := tmp.Write(content); err != nil {
+		return IOError(err, "could not write %s", path)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return IOError(err, "could not write %s", path)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return IOError(err, "could not write %s", path)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return IOError(err, "could not replace %s", path)
+	}
+
+	// the rename itself is only durable once the directory is synced
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		log.Debug().Err(err).Str("file-path", path).Msg("could not sync directory")
+	}
+
+	return nil
+}
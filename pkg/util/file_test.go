@@ -0,0 +1,75 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("old"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(link, []byte("new"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "new" {
+		t.Errorf("expected the target of the link to be replaced, got %q, %v", content, err)
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected the link to be kept, got %v, %v", info, err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("expected the mode to be preserved, got %v, %v", info, err)
+	}
+
+	newPath := filepath.Join(dir, "new")
+	if err := WriteFileAtomic(newPath, []byte("new"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if info, err := os.Stat(newPath); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a new file to get the given mode, got %v, %v", info, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 3 {
+		t.Errorf("expected no temporary files to be left, got %v, %v", entries, err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+
+	unlock, err := LockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := LockFile(path, 200*time.Millisecond); ErrorKindOf(err) != KindTimeout {
+		t.Errorf("expected a timeout while the lock is held, got %v", err)
+	}
+
+	unlock()
+
+	unlock, err = LockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("expected the released lock to be taken again, got %s", err)
+	}
+
+	unlock()
+}
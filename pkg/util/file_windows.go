@@ -0,0 +1,60 @@
+//go:build windows
+
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// the error of opening a file that another process opened without sharing it
+const errorSharingViolation syscall.Errno = 32
+
+// tryLock opens the lock file without sharing it, so that it can't be opened again until it is closed
+func tryLock(path string) (release func() error, err error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, errLocked
+		}
+
+		return nil, err
+	}
+
+	release = func() error {
+		return syscall.CloseHandle(handle)
+	}
+
+	return release, nil
+}
+
+// preserveOwner does nothing, a replaced file inherits the permissions of its directory on Windows
+func preserveOwner(*os.File, fs.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing, directories can't be synced on Windows
+func syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,304 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/rs/zerolog/log"
+)
+
+// Filters select the roles to generate profiles for. Every kind of filter is applied on its own: a role has to match
+// one of the includes, if there are any, and none of the excludes. Account names are matched with globs, or with a
+// regular expression if the pattern is enclosed in slashes like /^payments-.*$/.
+type Filters struct {
+	IncludeAccounts     []string `json:"include_accounts"`
+	ExcludeAccounts     []string `json:"exclude_accounts"`
+	IncludeAccountNames []string `json:"include_account_names"`
+	ExcludeAccountNames []string `json:"exclude_account_names"`
+	// Globs matching the role name without its path
+	IncludeRoles []string `json:"include_roles"`
+	ExcludeRoles []string `json:"exclude_roles"`
+	// Globs matching the OU path of an account or one of its parent OUs, e.g. workloads/*
+	IncludeOUs []string `json:"include_ous"`
+	ExcludeOUs []string `json:"exclude_ous"`
+	// Account tags as key=value, where the value may be a glob, or just key to match any value
+	IncludeTags []string `json:"include_tags"`
+	ExcludeTags []string `json:"exclude_tags"`
+	// The stage of the account, as set in the aliases or matched by the stage model
+	IncludeStages []string `json:"include_stages"`
+	ExcludeStages []string `json:"exclude_stages"`
+
+	regexps map[string]*regexp.Regexp
+}
+
+// FilterResult is the number of roles removed by a filter
+type FilterResult struct {
+	Filter  string
+	Removed int
+}
+
+// filterTarget is what filters are matched against
+type filterTarget struct {
+	accountID string
+	account   string
+	role      string
+	ouPath    string
+	tags      map[string]string
+	stage     string
+}
+
+type filter struct {
+	name    string
+	include bool
+	values  []string
+	matches func(target filterTarget, value string) bool
+}
+
+// Merge returns filters containing the filters of both f and other
+func (f *Filters) Merge(other *Filters) *Filters {
+	if f == nil {
+		return other
+	}
+
+	if other == nil {
+		return f
+	}
+
+	return &Filters{
+		IncludeAccounts:     append(append([]string{}, f.IncludeAccounts...), other.IncludeAccounts...),
+		ExcludeAccounts:     append(append([]string{}, f.ExcludeAccounts...), other.ExcludeAccounts...),
+		IncludeAccountNames: append(append([]string{}, f.IncludeAccountNames...), other.IncludeAccountNames...),
+		ExcludeAccountNames: append(append([]string{}, f.ExcludeAccountNames...), other.ExcludeAccountNames...),
+		IncludeRoles:        append(append([]string{}, f.IncludeRoles...), other.IncludeRoles...),
+		ExcludeRoles:        append(append([]string{}, f.ExcludeRoles...), other.ExcludeRoles...),
+		IncludeOUs:          append(append([]string{}, f.IncludeOUs...), other.IncludeOUs...),
+		ExcludeOUs:          append(append([]string{}, f.ExcludeOUs...), other.ExcludeOUs...),
+		IncludeTags:         append(append([]string{}, f.IncludeTags...), other.IncludeTags...),
+		ExcludeTags:         append(append([]string{}, f.ExcludeTags...), other.ExcludeTags...),
+		IncludeStages:       append(append([]string{}, f.IncludeStages...), other.IncludeStages...),
+		ExcludeStages:       append(append([]string{}, f.ExcludeStages...), other.ExcludeStages...),
+	}
+}
+
+// Compile validates the patterns, which Apply does as well, e.g. to fail early
+func (f *Filters) Compile() error {
+	f.regexps = map[string]*regexp.Regexp{}
+
+	for _, pattern := range append(append([]string{}, f.IncludeAccountNames...), f.ExcludeAccountNames...) {
+		if expr, ok := regexpPattern(pattern); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return ConfigError(err, "invalid account name pattern %q", pattern)
+			}
+
+			f.regexps[pattern] = re
+		} else if _, err := path.Match(pattern, ""); err != nil {
+			return ConfigError(err, "invalid account name pattern %q", pattern)
+		}
+	}
+
+	globs := [][]string{f.IncludeRoles, f.ExcludeRoles, f.IncludeOUs, f.ExcludeOUs}
+	for _, tag := range append(append([]string{}, f.IncludeTags...), f.ExcludeTags...) {
+		if _, value, ok := strings.Cut(tag, "="); ok {
+			globs = append(globs, []string{value})
+		}
+	}
+
+	for _, patterns := range globs {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return ConfigError(err, "invalid filter pattern %q", pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NeedsAccountDetails reports whether the filters use OUs or tags, which require AWSContext.WithAccountDetails
+func (f *Filters) NeedsAccountDetails() bool {
+	return f != nil && len(f.IncludeOUs)+len(f.ExcludeOUs)+len(f.IncludeTags)+len(f.ExcludeTags) > 0
+}
+
+func regexpPattern(pattern string) (string, bool) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return pattern[1 : len(pattern)-1], true
+	}
+
+	return "", false
+}
+
+func globMatches(pattern, value string) bool {
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+func (f *Filters) accountNameMatches(target filterTarget, pattern string) bool {
+	if re, ok := f.regexps[pattern]; ok {
+		return re.MatchString(target.account)
+	}
+
+	return globMatches(pattern, target.account)
+}
+
+func ouMatches(target filterTarget, pattern string) bool {
+	ous := strings.Split(target.ouPath, "/")
+
+	// an OU includes all OUs below it
+	for i := len(ous); i > 0; i-- {
+		if globMatches(pattern, strings.Join(ous[:i], "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tagMatches(target filterTarget, tag string) bool {
+	key, pattern, hasValue := strings.Cut(tag, "=")
+
+	value, ok := target.tags[key]
+	if !ok {
+		return false
+	}
+
+	return !hasValue || globMatches(pattern, value)
+}
+
+func (f *Filters) filters() []filter {
+	equals := func(actual func(filterTarget) string) func(filterTarget, string) bool {
+		return func(target filterTarget, value string) bool { return actual(target) == value }
+	}
+	accountID := equals(func(t filterTarget) string { return t.accountID })
+	stage := equals(func(t filterTarget) string { return t.stage })
+	role := func(target filterTarget, pattern string) bool { return globMatches(pattern, target.role) }
+
+	return []filter{
+		{name: "include-account", include: true, values: f.IncludeAccounts, matches: accountID},
+		{name: "exclude-account", values: f.ExcludeAccounts, matches: accountID},
+		{name: "include-account-name", include: true, values: f.IncludeAccountNames, matches: f.accountNameMatches},
+		{name: "exclude-account-name", values: f.ExcludeAccountNames, matches: f.accountNameMatches},
+		{name: "include-role", include: true, values: f.IncludeRoles, matches: role},
+		{name: "exclude-role", values: f.ExcludeRoles, matches: role},
+		{name: "include-ou", include: true, values: f.IncludeOUs, matches: ouMatches},
+		{name: "exclude-ou", values: f.ExcludeOUs, matches: ouMatches},
+		{name: "include-tag", include: true, values: f.IncludeTags, matches: tagMatches},
+		{name: "exclude-tag", values: f.ExcludeTags, matches: tagMatches},
+		{name: "include-stage", include: true, values: f.IncludeStages, matches: stage},
+		{name: "exclude-stage", values: f.ExcludeStages, matches: stage},
+	}
+}
+
+// keeps reports whether a role passes the filter
+func (flt filter) keeps(target filterTarget) bool {
+	for _, value := range flt.values {
+		if flt.matches(target, value) {
+			return flt.include
+		}
+	}
+
+	return !flt.include
+}
+
+// matches reports whether the target passes all filters, nil filters match everything
+func (f *Filters) matches(target filterTarget) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, flt := range f.filters() {
+		if len(flt.values) > 0 && !flt.keeps(target) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Apply returns a copy of the inventory with only the roles passing all filters, along with the number of roles every
+// filter removed. A role removed by several filters counts for each of them. Stages are matched against the account
+// names after nameRules, like those of profiles.
+func (f *Filters) Apply(inventory *Inventory, aliases Aliases, nameRules *NameRules, stages *StageModel) (*Inventory,
+	[]FilterResult, error) {
+	if err := f.Compile(); err != nil {
+		return nil, nil, err
+	}
+
+	filtered := *inventory
+	filtered.RoleArns = nil
+
+	var results []FilterResult
+
+	filters := f.filters()
+	removed := make([]int, len(filters))
+	seen := map[string]bool{}
+
+	for _, roleArn := range inventory.RoleArns {
+		role, err := arn.Parse(roleArn)
+		if err != nil {
+			filtered.RoleArns = append(filtered.RoleArns, roleArn)
+			continue
+		}
+
+		target := newFilterTarget(inventory, role, aliases, nameRules, stages)
+		keep := true
+
+		for i, flt := range filters {
+			if len(flt.values) > 0 && !flt.keeps(target) {
+				keep = false
+
+				// a role may appear several times, but only makes a single profile
+				if !seen[roleArn] {
+					removed[i]++
+				}
+			}
+		}
+
+		seen[roleArn] = true
+
+		if keep {
+			filtered.RoleArns = append(filtered.RoleArns, roleArn)
+		}
+	}
+
+	for i, flt := range filters {
+		if len(flt.values) > 0 {
+			results = append(results, FilterResult{Filter: flt.name, Removed: removed[i]})
+		}
+	}
+
+	return &filtered, results, nil
+}
+
+func newFilterTarget(inventory *Inventory, role arn.ARN, aliases Aliases, nameRules *NameRules,
+	stages *StageModel) filterTarget {
+	return filterTarget{
+		accountID: role.AccountID,
+		account:   aliases.accountName(inventory, role.AccountID, nil),
+		role:      path.Base(role.Resource),
+		ouPath:    inventory.AccountDetails[role.AccountID].OUPath,
+		tags:      inventory.AccountDetails[role.AccountID].Tags,
+		stage:     accountStage(inventory, role.AccountID, aliases, nameRules, stages),
+	}
+}
+
+func logFilterResults(results []FilterResult) {
+	for _, result := range results {
+		log.Info().Str("filter", result.Filter).Int("removed", result.Removed).Msg("Filtered profiles")
+	}
+}
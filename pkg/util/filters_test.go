@@ -0,0 +1,136 @@
+package util
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFiltersApply(t *testing.T) {
+	inventory := &Inventory{
+		Accounts: map[string]string{"11111": "payments-dev", "22222": "payments-prd", "33333": "data-prd"},
+		RoleArns: []string{
+			"*",
+			"arn:aws:iam::11111:role/admin",
+			"arn:aws:iam::11111:role/teams/developer",
+			"arn:aws:iam::22222:role/admin",
+			"arn:aws:iam::22222:role/admin",
+			"arn:aws:iam::33333:role/admin",
+			"arn:aws:iam::44444:role/admin",
+		},
+		AccountDetails: map[string]AccountDetails{
+			"11111": {OUPath: "workloads/payments", Tags: map[string]string{"team": "checkout"}},
+			"22222": {OUPath: "workloads/payments", Tags: map[string]string{"team": "checkout-core"}},
+			"33333": {OUPath: "workloads/data"},
+		},
+	}
+	aliases := Aliases{"44444": {Name: "partner", Stage: "prd"}}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		want    []string
+		results []FilterResult
+	}{
+		{name: "account ids",
+			filters: Filters{IncludeAccounts: []string{"11111", "22222"}, ExcludeAccounts: []string{"22222"}},
+			want:    []string{"arn:aws:iam::11111:role/admin", "arn:aws:iam::11111:role/teams/developer"},
+			results: []FilterResult{{Filter: "include-account", Removed: 2}, {Filter: "exclude-account", Removed: 1}}},
+		{name: "account name glob and regexp",
+			filters: Filters{IncludeAccountNames: []string{"payments-*", "/^part/"}, ExcludeAccountNames: []string{"*-dev"}},
+			want:    []string{"arn:aws:iam::22222:role/admin", "arn:aws:iam::44444:role/admin"}},
+		{name: "role names without path",
+			filters: Filters{ExcludeRoles: []string{"dev*"}},
+			want: []string{"arn:aws:iam::11111:role/admin", "arn:aws:iam::22222:role/admin",
+				"arn:aws:iam::33333:role/admin", "arn:aws:iam::44444:role/admin"}},
+		{name: "OUs include their children",
+			filters: Filters{IncludeOUs: []string{"workloads"}, ExcludeOUs: []string{"*/data"}},
+			want: []string{"arn:aws:iam::11111:role/admin", "arn:aws:iam::11111:role/teams/developer",
+				"arn:aws:iam::22222:role/admin"}},
+		{name: "tags",
+			filters: Filters{IncludeTags: []string{"team=checkout*"}, ExcludeTags: []string{"team=*-core"}},
+			want:    []string{"arn:aws:iam::11111:role/admin", "arn:aws:iam::11111:role/teams/developer"}},
+		{name: "stages from names and aliases",
+			filters: Filters{IncludeStages: []string{"prd"}, ExcludeAccounts: []string{"33333"}},
+			want:    []string{"arn:aws:iam::22222:role/admin", "arn:aws:iam::44444:role/admin"},
+			results: []FilterResult{{Filter: "exclude-account", Removed: 1}, {Filter: "include-stage", Removed: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered, results, err := tt.filters.Apply(inventory, aliases, nil, DefaultStageModel())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			profiles, err := GetProfiles("", filtered, ProfileOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []string
+			for _, profile := range profiles {
+				got = append(got, profile.RoleArn)
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() roles = %v, want %v", got, tt.want)
+			}
+
+			if tt.results != nil && !reflect.DeepEqual(results, tt.results) {
+				t.Errorf("Apply() results = %v, want %v", results, tt.results)
+			}
+		})
+	}
+}
+
+func TestFiltersStageAfterNameRules(t *testing.T) {
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "Payments (Prod)", "67890": "Payments (Dev)"},
+		RoleArns: []string{"arn:aws:iam::12345:role/admin", "arn:aws:iam::67890:role/admin"},
+	}
+
+	nameRules := &NameRules{Rewrites: []Rewrite{{Pattern: `\s*\(Prod\)$`, Replacement: "-prd"}}}
+	if err := nameRules.Compile(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	profiles, err := GetProfiles("", inventory, ProfileOptions{
+		NameRules: nameRules,
+		Filters:   &Filters{IncludeStages: []string{"prd"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(profiles) != 1 || profiles[0].AccountID != "12345" || profiles[0].Stage != "prd" {
+		t.Errorf("GetProfiles() = %+v, want the profile of 12345 with stage prd", profiles)
+	}
+}
+
+func TestFiltersCompile(t *testing.T) {
+	for _, filters := range []Filters{
+		{IncludeAccountNames: []string{"/(/"}},
+		{ExcludeRoles: []string{"["}},
+		{IncludeTags: []string{"team=["}},
+	} {
+		if err := filters.Compile(); ErrorKindOf(err) != KindConfig {
+			t.Errorf("Compile() of %+v error = %v, want a config error", filters, err)
+		}
+	}
+}
+
+func TestFiltersMerge(t *testing.T) {
+	config := &Filters{IncludeAccounts: []string{"11111"}}
+	flags := &Filters{IncludeAccounts: []string{"22222"}, ExcludeStages: []string{"prd"}}
+
+	merged := config.Merge(flags)
+	if !reflect.DeepEqual(merged.IncludeAccounts, []string{"11111", "22222"}) || !reflect.DeepEqual(merged.ExcludeStages, []string{"prd"}) {
+		t.Errorf("Merge() = %+v", merged)
+	}
+
+	if (*Filters)(nil).Merge(flags) != flags || config.Merge(nil) != config {
+		t.Errorf("expected merging with nil to return the other filters")
+	}
+}
@@ -14,17 +14,176 @@ package util
 */
 
 import (
-	"github.com/rs/zerolog/log"
+	"bytes"
+	"strings"
+
 	"gopkg.in/ini.v1"
 )
 
-func GetKeySetter(section *ini.Section) func(key, value string) {
-	return func(key, value string) {
+func GetKeySetter(section *ini.Section) func(key, value string) error {
+	return func(key, value string) error {
 		_, err := section.NewKey(key, value)
 		if err != nil {
-			log.Panic().Err(err).
-				Str("section", section.Name()).Str("key", key).Str("val", value).
-				Msg("could not set")
+			return ConfigError(err, "could not set key %s=%s in section %s", key, value, section.Name())
 		}
+
+		return nil
+	}
+}
+
+// iniChunk is the text of a section in an INI file, starting with the comments and blank lines since the last key of
+// the section before it, which ini reads as the comment of the section
+type iniChunk struct {
+	// empty for the comments after the last key of a file, which don't belong to any section
+	section string
+	text    string
+}
+
+// RenderPreserving renders config like ini.File.WriteTo, in the order of its sections, but keeps the text of the
+// sections of original that didn't change byte-identical, including the alignment and comments that ini would rewrite
+// or drop. Changed and new sections are rendered by ini.
+func RenderPreserving(original []byte, config *ini.File) ([]byte, error) {
+	var rendered bytes.Buffer
+	if _, err := config.WriteTo(&rendered); err != nil {
+		return nil, IOError(err, "could not render config")
+	}
+
+	originalConfig, err := ini.Load(original)
+	if err != nil || len(bytes.TrimSpace(original)) == 0 {
+		return rendered.Bytes(), nil
+	}
+
+	// the indices of the chunks of every section of original, a section that appears several times is kept as it is,
+	// with all of its parts
+	originalTexts := splitINI(string(original))
+	originalChunks := map[string][]int{}
+
+	for i, chunk := range originalTexts {
+		originalChunks[chunk.section] = append(originalChunks[chunk.section], i)
+	}
+
+	var out strings.Builder
+
+	// the index of the chunk of original written last, or -1 if it was rendered
+	lastOriginal := -1
+
+	write := func(text string, isRendered bool) {
+		if out.Len() == 0 {
+			text = strings.TrimLeft(text, "\n")
+		} else {
+			if !strings.HasSuffix(out.String(), "\n") {
+				out.WriteString("\n")
+			}
+
+			// keep rendered and moved sections apart from the ones before them, like ini does
+			if isRendered && !strings.HasPrefix(text, "\n") && !strings.HasSuffix(out.String(), "\n\n") {
+				out.WriteString("\n")
+			}
+		}
+
+		out.WriteString(text)
+	}
+
+	writeOriginal := func(indices []int) {
+		for _, i := range indices {
+			write(originalTexts[i].text, lastOriginal != i-1)
+			lastOriginal = i
+		}
+	}
+
+	for _, chunk := range splitINI(rendered.String()) {
+		if chunk.section == "" {
+			continue
+		}
+
+		originalSection, err := originalConfig.GetSection(chunk.section)
+		if err != nil || len(originalChunks[chunk.section]) == 0 ||
+			!sectionsEqual(originalSection, config.Section(chunk.section)) {
+			write(chunk.text, true)
+			lastOriginal = -1
+
+			continue
+		}
+
+		writeOriginal(originalChunks[chunk.section])
 	}
+
+	// comments at the end of the file stay at the end
+	writeOriginal(originalChunks[""])
+
+	return []byte(out.String()), nil
+}
+
+// splitINI splits content into the chunks of its sections, cutting it where ini would
+func splitINI(content string) []iniChunk {
+	var (
+		chunks  []iniChunk
+		lines   []string
+		lastKey = -1
+		section = ini.DefaultSection
+	)
+
+	appendChunk := func(section string, lines []string) {
+		if text := strings.Join(lines, ""); text != "" {
+			chunks = append(chunks, iniChunk{section: section, text: text})
+		}
+	}
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "["):
+			// the comments and blank lines since the last key belong to the new section
+			appendChunk(section, lines[:lastKey+1])
+			lines = append([]string(nil), lines[lastKey+1:]...)
+			section = iniSectionName(trimmed)
+			lines = append(lines, line)
+			lastKey = len(lines) - 1
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			lines = append(lines, line)
+		default:
+			lines = append(lines, line)
+			lastKey = len(lines) - 1
+		}
+	}
+
+	appendChunk(section, lines[:lastKey+1])
+	appendChunk("", lines[lastKey+1:])
+
+	return chunks
+}
+
+// iniSectionName returns the name of a section header the way ini reads it
+func iniSectionName(header string) string {
+	end := strings.LastIndex(header, "]")
+	if end < 0 {
+		end = len(header)
+	}
+
+	name := strings.TrimSpace(header[1:end])
+	if name == "" || name == ini.DefaultSection {
+		return ini.DefaultSection
+	}
+
+	return name
+}
+
+func sectionsEqual(a, b *ini.Section) bool {
+	if a.Comment != b.Comment || len(a.Keys()) != len(b.Keys()) {
+		return false
+	}
+
+	for i, key := range a.Keys() {
+		other := b.Keys()[i]
+		if key.Name() != other.Name() || key.Value() != other.Value() || key.Comment != other.Comment {
+			return false
+		}
+	}
+
+	return true
 }
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+func TestRenderPreserving(t *testing.T) {
+	original := `; my config
+[default]
+region=eu-central-1 ; inline comment
+output   =   json
+
+# notes about the next profile
+
+[profile hand-written]
+# the role
+role_arn=arn:aws:iam::12345:role/admin
+[profile hand-written]
+source_profile=default
+
+# generated by aws-cfg-generator
+[profile changed]
+role_arn = arn:aws:iam::12345:role/old
+
+# generated by aws-cfg-generator
+[profile removed]
+role_arn = arn:aws:iam::12345:role/removed
+
+[profile last]
+region=us-east-1
+
+# vim: ft=dosini
+`
+
+	t.Run("unchanged", func(t *testing.T) {
+		config, err := ini.Load([]byte(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := RenderPreserving([]byte(original), config)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if string(content) != original {
+			t.Errorf("RenderPreserving() =\n%s\nwant\n%s", content, original)
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		config, err := ini.Load([]byte(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config.Section("profile changed").Key("role_arn").SetValue("arn:aws:iam::12345:role/new")
+		config.DeleteSection("profile removed")
+
+		added := config.Section("profile added")
+		added.Comment = "# generated by aws-cfg-generator"
+		added.Key("role_arn").SetValue("arn:aws:iam::12345:role/added")
+
+		content, err := RenderPreserving([]byte(original), config)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		want := `; my config
+[default]
+region=eu-central-1 ; inline comment
+output   =   json
+
+# notes about the next profile
+
+[profile hand-written]
+# the role
+role_arn=arn:aws:iam::12345:role/admin
+[profile hand-written]
+source_profile=default
+
+# generated by aws-cfg-generator
+[profile changed]
+role_arn = arn:aws:iam::12345:role/new
+
+[profile last]
+region=us-east-1
+
+# generated by aws-cfg-generator
+[profile added]
+role_arn = arn:aws:iam::12345:role/added
+
+# vim: ft=dosini
+`
+		if string(content) != want {
+			t.Errorf("RenderPreserving() =\n%s\nwant\n%s", content, want)
+		}
+	})
+
+	t.Run("reordered", func(t *testing.T) {
+		config, err := ini.Load([]byte(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reordered := ini.Empty()
+		for _, name := range []string{"profile last", "profile changed", "default", "profile hand-written"} {
+			section := config.Section(name)
+			newSection := reordered.Section(name)
+			newSection.Comment = section.Comment
+
+			for _, key := range section.Keys() {
+				newSection.Key(key.Name()).SetValue(key.Value())
+				newSection.Key(key.Name()).Comment = key.Comment
+			}
+		}
+
+		content, err := RenderPreserving([]byte(original), reordered)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		want := `[profile last]
+region=us-east-1
+
+# generated by aws-cfg-generator
+[profile changed]
+role_arn = arn:aws:iam::12345:role/old
+
+; my config
+[default]
+region=eu-central-1 ; inline comment
+output   =   json
+
+# notes about the next profile
+
+[profile hand-written]
+# the role
+role_arn=arn:aws:iam::12345:role/admin
+[profile hand-written]
+source_profile=default
+
+# vim: ft=dosini
+`
+		if string(content) != want {
+			t.Errorf("RenderPreserving() =\n%s\nwant\n%s", content, want)
+		}
+	})
+
+	t.Run("new file", func(t *testing.T) {
+		config := ini.Empty()
+		config.Section("default").Key("region").SetValue("eu-central-1")
+
+		content, err := RenderPreserving(nil, config)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if want := "[default]\nregion = eu-central-1\n"; string(content) != want {
+			t.Errorf("RenderPreserving() = %q, want %q", content, want)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// InventoryVersion is the version of the snapshot format written by Inventory.Save
+const InventoryVersion = 1
+
+// Grant records a policy statement of a group, or of the role of the caller, that allows assuming a role
+type Grant struct {
+	RoleArn string `json:"role_arn"`
+	Group   string `json:"group"`
+	// the role whose policy grants the role if the caller is an assumed role session, Group is empty then
+	SourceRole string `json:"source_role,omitempty"`
+	// the ARN of an attached policy or the name of an inline policy
+	Policy string `json:"policy"`
+	Sid    string `json:"sid,omitempty"`
+}
+
+func (g Grant) String() string {
+	source := fmt.Sprintf("group %s, policy %s", g.Group, g.Policy)
+	if g.SourceRole != "" {
+		source = fmt.Sprintf("role %s, policy %s", g.SourceRole, g.Policy)
+	}
+
+	if g.Sid != "" {
+		source = fmt.Sprintf("%s, statement %s", source, g.Sid)
+	}
+
+	return source
+}
+
+// Inventory is everything discovery found out about the caller, and is all generation needs
+type Inventory struct {
+	Version         int               `json:"version"`
+	GeneratedAt     time.Time         `json:"generated_at"`
+	CallerArn       string            `json:"caller_arn"`
+	SkippedPolicies int               `json:"skipped_policies"`
+	Accounts        map[string]string `json:"accounts"`
+	RoleArns        []string          `json:"role_arns"`
+	Grants          []Grant           `json:"grants"`
+	// only set if discovery looked up organizational units and tags, see AWSContext.WithAccountDetails
+	AccountDetails map[string]AccountDetails `json:"account_details"`
+}
+
+func newInventory(callerArn string, accountMap map[string]string, grants []Grant) *Inventory {
+	// discovery runs concurrently, so sort to get the same inventory for the same permissions
+	slices.SortFunc(grants, func(x, y Grant) bool {
+		if x.RoleArn != y.RoleArn {
+			return x.RoleArn < y.RoleArn
+		}
+
+		return x.String() < y.String()
+	})
+
+	return &Inventory{
+		Version:     InventoryVersion,
+		GeneratedAt: time.Now().UTC(),
+		CallerArn:   callerArn,
+		Accounts:    accountMap,
+		RoleArns:    grantedRoleArns(grants),
+		Grants:      grants,
+	}
+}
+
+func grantedRoleArns(grants []Grant) []string {
+	roleArns := make([]string, 0, len(grants))
+
+	for _, grant := range grants {
+		roleArns = append(roleArns, grant.RoleArn)
+	}
+
+	return roleArns
+}
+
+// grantsByRole returns the distinct grants of every role
+func (inv *Inventory) grantsByRole() map[string][]Grant {
+	grants := map[string][]Grant{}
+	seen := map[Grant]bool{}
+
+	for _, grant := range inv.Grants {
+		if seen[grant] {
+			continue
+		}
+
+		seen[grant] = true
+		grants[grant.RoleArn] = append(grants[grant.RoleArn], grant)
+	}
+
+	// discovery runs concurrently, so sort to get a deterministic order
+	for _, roleGrants := range grants {
+		slices.SortFunc(roleGrants, func(x, y Grant) bool {
+			return x.String() < y.String()
+		})
+	}
+
+	return grants
+}
+
+// AddOrgRole adds role for every account in the organization, unless the inventory already contains it
+func (inv *Inventory) AddOrgRole(role string) {
+	if role == "" {
+		return
+	}
+
+	known := map[string]bool{}
+	for _, roleArn := range inv.RoleArns {
+		known[roleArn] = true
+	}
+
+	var orgRoleArns []string
+
+	for _, roleArn := range generateOrgRoleArns(inv.Accounts, role) {
+		if !known[roleArn] {
+			orgRoleArns = append(orgRoleArns, roleArn)
+		}
+	}
+
+	inv.RoleArns = append(orgRoleArns, inv.RoleArns...)
+}
+
+// Save writes the inventory as a JSON snapshot to path
+func (inv *Inventory) Save(path string) error {
+	content, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return newError(KindUnknown, err, "could not marshal inventory")
+	}
+
+	err = os.WriteFile(path, append(content, '\n'), 0o600)
+	if err != nil {
+		return IOError(err, "could not save inventory %s", path)
+	}
+
+	return nil
+}
+
+// ReadInventory loads a snapshot written by Inventory.Save
+func ReadInventory(path string) (*Inventory, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, IOError(err, "could not read inventory %s", path)
+	}
+
+	var inventory Inventory
+
+	err = json.Unmarshal(content, &inventory)
+	if err != nil {
+		return nil, ConfigError(err, "could not parse inventory %s", path)
+	}
+
+	if inventory.Version != InventoryVersion {
+		return nil, ConfigError(nil, "inventory %s has version %d, but only version %d is supported",
+			path, inventory.Version, InventoryVersion)
+	}
+
+	if inventory.Accounts == nil {
+		inventory.Accounts = map[string]string{}
+	}
+
+	return &inventory, nil
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestInventorySaveAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	inventory := newInventory("arn:aws:iam::11111:user/jane", map[string]string{"12345": "my-account"}, []Grant{
+		{RoleArn: "arn:aws:iam::12345:role/my-role", Group: "developers", Policy: "arn:aws:iam::11111:policy/dev"},
+	})
+
+	if err := inventory.Save(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := ReadInventory(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(got, inventory) {
+		t.Errorf("ReadInventory() = %v, want %v", got, inventory)
+	}
+}
+
+func TestReadInventoryRejectsUnknownVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	if err := os.WriteFile(path, []byte(`{"version": 99}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadInventory(path)
+	if kind := ErrorKindOf(err); kind != KindConfig {
+		t.Errorf("ReadInventory() error kind = %s, want %s (%v)", kind, KindConfig, err)
+	}
+}
+
+func TestInventoryAddOrgRole(t *testing.T) {
+	inventory := newInventory("", map[string]string{"12345": "my-account"}, []Grant{
+		{RoleArn: "arn:aws:iam::12345:role/admin"},
+		{RoleArn: "arn:aws:iam::67890:role/admin"},
+	})
+
+	inventory.AddOrgRole("admin")
+	inventory.AddOrgRole("reader")
+
+	want := []string{"arn:aws:iam::12345:role/reader", "arn:aws:iam::12345:role/admin", "arn:aws:iam::67890:role/admin"}
+	if !reflect.DeepEqual(inventory.RoleArns, want) {
+		t.Errorf("AddOrgRole() = %v, want %v", inventory.RoleArns, want)
+	}
+}
+
+func TestInventoryAddOrgRoleIsDeterministic(t *testing.T) {
+	accounts := map[string]string{}
+	for i := 0; i < 20; i++ {
+		accounts[fmt.Sprintf("%05d", 10000+i*7)] = fmt.Sprintf("account-%d", i)
+	}
+
+	var first []string
+
+	// maps are iterated in a random order, so repeated calls would differ if it leaked into the role ARNs
+	for i := 0; i < 10; i++ {
+		inventory := newInventory("", accounts, nil)
+		inventory.AddOrgRole("admin")
+
+		if i == 0 {
+			first = inventory.RoleArns
+			continue
+		}
+
+		if !reflect.DeepEqual(inventory.RoleArns, first) {
+			t.Fatalf("AddOrgRole() = %v, want %v", inventory.RoleArns, first)
+		}
+	}
+
+	if !slices.IsSorted(first) {
+		t.Errorf("AddOrgRole() = %v, want the roles sorted by account ID", first)
+	}
+}
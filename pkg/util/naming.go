@@ -0,0 +1,147 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NameRules normalize account and role names before they are used in profile names. They are applied in this order:
+// strip prefixes and suffixes, rewrite, lowercase, replace invalid characters.
+type NameRules struct {
+	// Prefixes removed from the start of a name, only the first matching one is removed
+	StripPrefixes []string `json:"strip_prefixes"`
+	// Suffixes removed from the end of a name, only the first matching one is removed
+	StripSuffixes []string `json:"strip_suffixes"`
+	// Regular expression replacements applied one after another
+	Rewrites []Rewrite `json:"rewrites"`
+	// Convert names to lower case
+	Lowercase bool `json:"lowercase"`
+	// Replace every run of characters other than letters, digits, '.', '_' and '-' with InvalidReplacement
+	ReplaceInvalid bool `json:"replace_invalid"`
+	// Defaults to '-'
+	InvalidReplacement *string `json:"invalid_replacement"`
+}
+
+// Rewrite replaces all matches of Pattern with Replacement, which may refer to submatches like $1
+type Rewrite struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	regexp *regexp.Regexp
+}
+
+var invalidNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Compile validates the rules and must be called before they are applied, ReadConfig does so for loaded rules
+func (r *NameRules) Compile() error {
+	for i := range r.Rewrites {
+		re, err := regexp.Compile(r.Rewrites[i].Pattern)
+		if err != nil {
+			return ConfigError(err, "invalid rewrite pattern %q", r.Rewrites[i].Pattern)
+		}
+
+		r.Rewrites[i].regexp = re
+	}
+
+	if r.InvalidReplacement != nil && invalidNameCharacters.MatchString(*r.InvalidReplacement) {
+		return ConfigError(nil, "invalid replacement %q must only contain valid characters", *r.InvalidReplacement)
+	}
+
+	return nil
+}
+
+// Apply normalizes name, rules may be nil to keep the name as it is
+func (r *NameRules) Apply(name string) string {
+	if r == nil {
+		return name
+	}
+
+	for _, prefix := range r.StripPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+
+	for _, suffix := range r.StripSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+
+	for _, rewrite := range r.Rewrites {
+		name = rewrite.regexp.ReplaceAllString(name, rewrite.Replacement)
+	}
+
+	if r.Lowercase {
+		name = strings.ToLower(name)
+	}
+
+	if r.ReplaceInvalid {
+		replacement := "-"
+		if r.InvalidReplacement != nil {
+			replacement = *r.InvalidReplacement
+		}
+
+		name = invalidNameCharacters.ReplaceAllString(strings.TrimSpace(name), replacement)
+
+		if replacement != "" {
+			name = strings.Trim(name, replacement)
+		}
+	}
+
+	return name
+}
+
+// ProfileTarget is the tool a profile name has to be valid for
+type ProfileTarget string
+
+const (
+	TargetVault       ProfileTarget = "aws-vault"
+	TargetAWSCLI      ProfileTarget = "aws-cli"
+	TargetSwitchRoles ProfileTarget = "aws-extend-switch-roles"
+)
+
+// validate makes sure that a profile name can be used as an INI section name of the target tool
+func (t ProfileTarget) validate(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return ConfigError(nil, "profile name must not be empty")
+	}
+
+	if strings.TrimSpace(name) != name {
+		return ConfigError(nil, "profile name %q must not start or end with whitespace", name)
+	}
+
+	if strings.ContainsAny(name, "[]\r\n") {
+		return ConfigError(nil, "profile name %q must not contain brackets or line breaks", name)
+	}
+
+	switch t {
+	case TargetVault, TargetAWSCLI:
+		// the AWS CLI reads [profile default] as the default profile
+		if name == "default" {
+			return ConfigError(nil, "profile name %q is reserved by the AWS CLI", name)
+		}
+	case TargetSwitchRoles:
+		// the extension reads [profile foo] as foo
+		if strings.HasPrefix(name, "profile ") {
+			return ConfigError(nil, "profile name %q must not start with 'profile '", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNameRulesApply(t *testing.T) {
+	empty := ""
+
+	tests := []struct {
+		name  string
+		rules *NameRules
+		input string
+		want  string
+	}{
+		{name: "no rules",
+			input: "MOIA - Payments (Prod)",
+			want:  "MOIA - Payments (Prod)"},
+		{name: "full pipeline",
+			rules: &NameRules{
+				StripPrefixes:  []string{"Other - ", "MOIA - "},
+				Rewrites:       []Rewrite{{Pattern: `\s*\(Prod\)$`, Replacement: "-prd"}, {Pattern: `-prd$`, Replacement: ".prd"}},
+				Lowercase:      true,
+				ReplaceInvalid: true,
+			},
+			input: "MOIA - Payments (Prod)",
+			want:  "payments.prd"},
+		{name: "invalid characters",
+			rules: &NameRules{ReplaceInvalid: true},
+			input: " Data & Analytics (Dev) ",
+			want:  "Data-Analytics-Dev"},
+		{name: "custom replacement",
+			rules: &NameRules{ReplaceInvalid: true, InvalidReplacement: &empty},
+			input: "data lake",
+			want:  "datalake"},
+		{name: "suffix",
+			rules: &NameRules{StripSuffixes: []string{"-account"}},
+			input: "payments-account",
+			want:  "payments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rules != nil {
+				if err := tt.rules.Compile(); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			if got := tt.rules.Apply(tt.input); got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProfilesWithNameRules(t *testing.T) {
+	rules := &NameRules{StripPrefixes: []string{"MOIA - "}, Lowercase: true, ReplaceInvalid: true}
+	if err := rules.Compile(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "MOIA - Payments (Prod)"},
+		RoleArns: []string{"arn:aws:iam::12345:role/teams/Admin"},
+	}
+
+	profiles, err := GetProfiles("profile ", inventory, ProfileOptions{UseRoleName: true, NameRules: rules, Target: TargetVault})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := Profile{
+		RoleArn:     "arn:aws:iam::12345:role/teams/Admin",
+		RoleName:    "teams/Admin",
+		ProfileName: "profile payments-prod_teams-admin",
+		AccountID:   "12345",
+		AccountName: "payments-prod",
+	}
+	if len(profiles) != 1 || !reflect.DeepEqual(profiles[0], want) {
+		t.Errorf("GetProfiles() = %v, want %v", profiles, want)
+	}
+}
+
+func TestProfileTargetValidate(t *testing.T) {
+	tests := []struct {
+		target  ProfileTarget
+		name    string
+		wantErr bool
+	}{
+		{target: TargetVault, name: "payments prd"},
+		{target: TargetVault, name: "default", wantErr: true},
+		{target: TargetVault, name: "payments]", wantErr: true},
+		{target: TargetSwitchRoles, name: "default"},
+		{target: TargetSwitchRoles, name: "profile payments", wantErr: true},
+		{target: TargetSwitchRoles, name: " payments", wantErr: true},
+		{target: TargetSwitchRoles, name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.target.validate(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate(%q) error = %v, wantErr %v", tt.target, tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid", content: `{"name_rules": {"lowercase": true, "rewrites": [{"pattern": "^a(.*)", "replacement": "$1"}]}}`},
+		{name: "unknown key", content: `{"name_rules": {"lowercas": true}}`, wantErr: true},
+		{name: "invalid pattern", content: `{"name_rules": {"rewrites": [{"pattern": "("}]}}`, wantErr: true},
+		{name: "invalid replacement", content: `{"name_rules": {"invalid_replacement": " "}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := ReadConfig(path)
+			if tt.wantErr && ErrorKindOf(err) != KindConfig {
+				t.Errorf("ReadConfig() error = %v, want a config error", err)
+			}
+
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
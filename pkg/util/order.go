@@ -15,10 +15,23 @@ package util
 
 import (
 	"golang.org/x/exp/slices"
-	"strings"
 )
 
-var stages = []string{"poc", "stg", "dev", "int", "prd"}
+// OrderStrategy decides the order of the generated profiles
+type OrderStrategy string
+
+const (
+	// OrderAlphabetical sorts by profile name, with profiles of a stage after the ones without
+	OrderAlphabetical OrderStrategy = "alphabetical"
+	// OrderByAccount groups the stages of an account, e.g. payments-dev and payments-prd, in pipeline order
+	OrderByAccount OrderStrategy = "account"
+	// OrderByRole groups the profiles by role name
+	OrderByRole OrderStrategy = "role"
+	// OrderByOU groups the profiles by the OU path of their account
+	OrderByOU OrderStrategy = "ou"
+	// OrderRecent puts the most recently used roles first, followed by the rest in alphabetical order
+	OrderRecent OrderStrategy = "recent"
+)
 
 func profileLess(x, y Profile) bool {
 	return x.ProfileName < y.ProfileName
@@ -43,10 +56,60 @@ func OrderProfiles(unorderedProfiles []Profile) []Profile {
 }
 
 func isStageProfile(profile Profile) bool {
-	for _, stage := range stages {
-		if strings.HasSuffix(profile.ProfileName, stage) {
-			return true
+	return profile.Stage != ""
+}
+
+// OrderProfilesBy orders profiles according to strategy. Ties are broken by profile name, so that the order is always
+// the same for the same profiles. The stages are needed for OrderByAccount, and the usage for OrderRecent.
+func OrderProfilesBy(profiles []Profile, strategy OrderStrategy, stages *StageModel, usage RoleUsage) ([]Profile, error) {
+	ordered := append([]Profile{}, profiles...)
+
+	switch strategy {
+	case OrderAlphabetical:
+		return OrderProfiles(ordered), nil
+	case OrderByAccount:
+		if stages == nil {
+			stages = DefaultStageModel()
 		}
+
+		slices.SortFunc(ordered, func(x, y Profile) bool {
+			xBase, yBase := stages.trimStage(x.AccountName, x.Stage), stages.trimStage(y.AccountName, y.Stage)
+			if xBase != yBase {
+				return xBase < yBase
+			}
+
+			if xRank, yRank := stages.Rank(x.Stage), stages.Rank(y.Stage); xRank != yRank {
+				return xRank < yRank
+			}
+
+			return profileLess(x, y)
+		})
+	case OrderByRole:
+		slices.SortFunc(ordered, func(x, y Profile) bool {
+			if x.RoleName != y.RoleName {
+				return x.RoleName < y.RoleName
+			}
+
+			return profileLess(x, y)
+		})
+	case OrderByOU:
+		slices.SortFunc(ordered, func(x, y Profile) bool {
+			if x.OUPath != y.OUPath {
+				return x.OUPath < y.OUPath
+			}
+
+			return profileLess(x, y)
+		})
+	case OrderRecent:
+		ordered = OrderProfiles(ordered)
+
+		// stable, so that the unused profiles keep their alphabetical order
+		slices.SortStableFunc(ordered, func(x, y Profile) bool {
+			return usage.lastUsed(x).After(usage.lastUsed(y))
+		})
+	default:
+		return nil, ConfigError(nil, "unknown order %q", strategy)
 	}
-	return false
+
+	return ordered, nil
 }
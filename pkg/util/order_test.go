@@ -3,8 +3,14 @@ package util
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
+// named returns a profile with the stage GetProfiles would find for its name
+func named(name string) Profile {
+	return Profile{ProfileName: name, Stage: DefaultStageModel().match(AccountDetails{}, name)}
+}
+
 func TestOrderProfiles(t *testing.T) {
 
 	tests := []struct {
@@ -14,54 +20,54 @@ func TestOrderProfiles(t *testing.T) {
 	}{
 		{name: "mixed profile list",
 			unorderedProfiles: []Profile{
-				{ProfileName: "bar.int"},
-				{ProfileName: "tools"},
-				{ProfileName: "foo.dev"},
-				{ProfileName: "cookies"},
-				{ProfileName: "bar.prd"},
-				{ProfileName: "gears"},
+				named("bar.int"),
+				named("tools"),
+				named("foo.dev"),
+				named("cookies"),
+				named("bar.prd"),
+				named("gears"),
 			},
 			want: []Profile{
-				{ProfileName: "cookies"},
-				{ProfileName: "gears"},
-				{ProfileName: "tools"},
-				{ProfileName: "bar.int"},
-				{ProfileName: "bar.prd"},
-				{ProfileName: "foo.dev"},
+				named("cookies"),
+				named("gears"),
+				named("tools"),
+				named("bar.int"),
+				named("bar.prd"),
+				named("foo.dev"),
 			}},
 		{name: "only single profiles",
 			unorderedProfiles: []Profile{
-				{ProfileName: "bar"},
-				{ProfileName: "tools"},
-				{ProfileName: "foo"},
-				{ProfileName: "cookies"},
-				{ProfileName: "bikes"},
-				{ProfileName: "gears"},
+				named("bar"),
+				named("tools"),
+				named("foo"),
+				named("cookies"),
+				named("bikes"),
+				named("gears"),
 			},
 			want: []Profile{
-				{ProfileName: "bar"},
-				{ProfileName: "bikes"},
-				{ProfileName: "cookies"},
-				{ProfileName: "foo"},
-				{ProfileName: "gears"},
-				{ProfileName: "tools"},
+				named("bar"),
+				named("bikes"),
+				named("cookies"),
+				named("foo"),
+				named("gears"),
+				named("tools"),
 			}},
 		{name: "only staged profiles",
 			unorderedProfiles: []Profile{
-				{ProfileName: "bar.int"},
-				{ProfileName: "tools.prd"},
-				{ProfileName: "foo.dev"},
-				{ProfileName: "cookies.dev"},
-				{ProfileName: "bar.prd"},
-				{ProfileName: "gears.poc"},
+				named("bar.int"),
+				named("tools.prd"),
+				named("foo.dev"),
+				named("cookies.dev"),
+				named("bar.prd"),
+				named("gears.poc"),
 			},
 			want: []Profile{
-				{ProfileName: "bar.int"},
-				{ProfileName: "bar.prd"},
-				{ProfileName: "cookies.dev"},
-				{ProfileName: "foo.dev"},
-				{ProfileName: "gears.poc"},
-				{ProfileName: "tools.prd"},
+				named("bar.int"),
+				named("bar.prd"),
+				named("cookies.dev"),
+				named("foo.dev"),
+				named("gears.poc"),
+				named("tools.prd"),
 			}},
 	}
 	for _, tt := range tests {
@@ -81,14 +87,14 @@ func Test_isStageProfile(t *testing.T) {
 		want    bool
 	}{
 		{name: "unique account",
-			profile: Profile{ProfileName: "cookies"},
+			profile: named("cookies"),
 			want:    false},
 		{name: "dev account",
-			profile: Profile{ProfileName: "cookies.dev"},
+			profile: named("cookies.dev"),
 			want:    true},
 		{name: "global account",
-			profile: Profile{ProfileName: "tools.global"},
-			want:    false},
+			profile: named("tools.global"),
+			want:    true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -98,3 +104,73 @@ func Test_isStageProfile(t *testing.T) {
 		})
 	}
 }
+
+func TestOrderProfilesBy(t *testing.T) {
+	profile := func(accountName, roleName, ouPath string) Profile {
+		name := accountName + "_" + roleName
+		return Profile{
+			ProfileName: name,
+			AccountID:   accountName,
+			AccountName: accountName,
+			RoleName:    roleName,
+			OUPath:      ouPath,
+			Stage:       DefaultStageModel().match(AccountDetails{}, accountName),
+		}
+	}
+
+	profiles := []Profile{
+		profile("payments-prd", "admin", "workloads/payments"),
+		profile("tools", "admin", ""),
+		profile("payments-dev", "developer", "workloads/payments"),
+		profile("payments-stg", "admin", "workloads/payments"),
+		profile("data-int", "admin", "workloads/data"),
+		profile("payments-dev", "admin", "workloads/payments"),
+	}
+
+	tests := []struct {
+		strategy OrderStrategy
+		want     []string
+	}{
+		{strategy: OrderAlphabetical,
+			want: []string{"tools_admin", "data-int_admin", "payments-dev_admin", "payments-dev_developer",
+				"payments-prd_admin", "payments-stg_admin"}},
+		{strategy: OrderByAccount,
+			want: []string{"data-int_admin", "payments-dev_admin", "payments-dev_developer", "payments-stg_admin",
+				"payments-prd_admin", "tools_admin"}},
+		{strategy: OrderByRole,
+			want: []string{"data-int_admin", "payments-dev_admin", "payments-prd_admin", "payments-stg_admin",
+				"tools_admin", "payments-dev_developer"}},
+		{strategy: OrderByOU,
+			want: []string{"tools_admin", "data-int_admin", "payments-dev_admin", "payments-dev_developer",
+				"payments-prd_admin", "payments-stg_admin"}},
+		{strategy: OrderRecent,
+			want: []string{"payments-stg_admin", "payments-dev_developer", "tools_admin", "data-int_admin",
+				"payments-dev_admin", "payments-prd_admin"}},
+	}
+	usage := RoleUsage{
+		usageKey("payments-dev", "developer"): time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		usageKey("payments-stg", "admin"):     time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			ordered, err := OrderProfilesBy(profiles, tt.strategy, nil, usage)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var got []string
+			for _, profile := range ordered {
+				got = append(got, profile.ProfileName)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OrderProfilesBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := OrderProfilesBy(profiles, "random", nil, nil); ErrorKindOf(err) != KindConfig {
+		t.Errorf("OrderProfilesBy() error = %v, want a config error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/rs/zerolog/log"
+)
+
+// AccountDetails is the organization metadata of an account, which is only discovered if a profile name needs it
+type AccountDetails struct {
+	// the names of the organizational units containing the account from the root down, separated by slashes
+	OUPath string            `json:"ou_path"`
+	Tags   map[string]string `json:"tags,omitempty"`
+}
+
+// maxAccountLookups bounds the number of accounts whose details are looked up at once, as the Organizations API only
+// allows a few requests per second
+const maxAccountLookups = 8
+
+// accountDetailsResult is used to pass the details of an account back over a channel
+type accountDetailsResult struct {
+	accountID string
+	details   AccountDetails
+	err       error
+}
+
+// ouNameCache remembers the names of organizational units, which are shared by the lookups of their accounts
+type ouNameCache struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func (c *ouNameCache) get(ouID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, ok := c.names[ouID]
+
+	return name, ok
+}
+
+func (c *ouNameCache) set(ouID, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.names[ouID] = name
+}
+
+// getAccountDetails looks up the organizational unit and tags of every account, up to maxAccountLookups accounts at
+// once. Like the account names, missing details are only logged so that a lack of permissions doesn't prevent
+// generating a config.
+func (ctx *AWSContext) getAccountDetails(accountMap map[string]string) (map[string]AccountDetails, error) {
+	ouNames := &ouNameCache{names: map[string]string{}}
+	slots := make(chan struct{}, maxAccountLookups)
+	c := make(chan accountDetailsResult, len(accountMap))
+
+	for accountID := range accountMap {
+		id := accountID
+
+		goLookup(c, func() accountDetailsResult {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.context.Done():
+				return accountDetailsResult{accountID: id}
+			}
+
+			return accountDetailsResult{accountID: id, details: ctx.getAccountDetail(id, ouNames)}
+		}, func(err error) accountDetailsResult {
+			return accountDetailsResult{accountID: id, err: err}
+		})
+	}
+
+	details := make(map[string]AccountDetails, len(accountMap))
+
+	for range accountMap {
+		select {
+		case result := <-c:
+			if result.err != nil {
+				return nil, result.err
+			}
+
+			details[result.accountID] = result.details
+		case <-ctx.context.Done():
+			return nil, ContextError(ctx.context.Err())
+		}
+	}
+
+	return details, nil
+}
+
+func (ctx *AWSContext) getAccountDetail(accountID string, ouNames *ouNameCache) AccountDetails {
+	ouPath, err := ctx.getOUPath(accountID, ouNames)
+	if err != nil {
+		log.Warn().Err(err).Str("account-id", accountID).Msg("could not find organizational unit of account")
+	}
+
+	tags, err := ctx.getAccountTags(accountID)
+	if err != nil {
+		log.Warn().Err(err).Str("account-id", accountID).Msg("could not list tags of account")
+	}
+
+	return AccountDetails{OUPath: ouPath, Tags: tags}
+}
+
+// getOUPath walks up from the account to the root, remembering the names of the organizational units in ouNames
+func (ctx *AWSContext) getOUPath(accountID string, ouNames *ouNameCache) (string, error) {
+	var path []string
+
+	childID := accountID
+
+	for {
+		callCtx, cancel := ctx.callContext()
+		lpo, err := ctx.org.ListParentsWithContext(callCtx, &organizations.ListParentsInput{ChildId: &childID})
+		cancel()
+
+		if err != nil {
+			return "", awsError(err, "could not list parents of %s", childID)
+		}
+
+		if len(lpo.Parents) == 0 || *lpo.Parents[0].Type != organizations.ParentTypeOrganizationalUnit {
+			break
+		}
+
+		childID = *lpo.Parents[0].Id
+
+		name, ok := ouNames.get(childID)
+		if !ok {
+			callCtx, cancel := ctx.callContext()
+			dou, err := ctx.org.DescribeOrganizationalUnitWithContext(callCtx, &organizations.DescribeOrganizationalUnitInput{
+				OrganizationalUnitId: &childID,
+			})
+			cancel()
+
+			if err != nil {
+				return "", awsError(err, "could not describe organizational unit %s", childID)
+			}
+
+			name = *dou.OrganizationalUnit.Name
+			ouNames.set(childID, name)
+		}
+
+		path = append([]string{name}, path...)
+	}
+
+	return strings.Join(path, "/"), nil
+}
+
+func (ctx *AWSContext) getAccountTags(accountID string) (map[string]string, error) {
+	tags := map[string]string{}
+
+	ltfri := &organizations.ListTagsForResourceInput{ResourceId: &accountID}
+
+	for {
+		callCtx, cancel := ctx.callContext()
+		ltfro, err := ctx.org.ListTagsForResourceWithContext(callCtx, ltfri)
+		cancel()
+
+		if err != nil {
+			return nil, awsError(err, "could not list tags of account %s", accountID)
+		}
+
+		for _, tag := range ltfro.Tags {
+			tags[*tag.Key] = *tag.Value
+		}
+
+		if ltfro.NextToken == nil {
+			break
+		}
+
+		ltfri.NextToken = ltfro.NextToken
+	}
+
+	return tags, nil
+}
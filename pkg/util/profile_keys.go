@@ -0,0 +1,179 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"golang.org/x/exp/slices"
+)
+
+// ProfileKeyRule sets additional keys in the generated profiles of the roles it matches
+type ProfileKeyRule struct {
+	// Selects roles like the filters do, a rule without it matches every role
+	Match *Filters `json:"match"`
+	// e.g. duration_seconds, mfa_serial, region, output, cli_pager, parent_profile, source_profile or, for the AWS CLI,
+	// credential_source
+	Keys map[string]string `json:"keys"`
+}
+
+// ProfileKeyRules are applied in order, so the keys of a rule override those of the rules before it
+type ProfileKeyRules []ProfileKeyRule
+
+// keys that are always set by the generator, include_profile follows source_profile
+var generatedProfileKeys = map[string]bool{
+	"role_arn":        true,
+	"include_profile": true,
+}
+
+// CredentialSources are the values of credential_source, which the AWS CLI and SDKs use instead of a source profile
+var CredentialSources = []string{"Ec2InstanceMetadata", "EcsContainer", "Environment"}
+
+const (
+	// the limits of the duration of an assumed role session
+	minDurationSeconds = 900
+	maxDurationSeconds = 43200
+)
+
+// Compile validates the rules and their patterns
+func (r ProfileKeyRules) Compile() error {
+	for i, rule := range r {
+		if len(rule.Keys) == 0 {
+			return ConfigError(nil, "profile key rule %d sets no keys", i+1)
+		}
+
+		if rule.Match != nil {
+			if err := rule.Match.Compile(); err != nil {
+				return ConfigError(err, "invalid match of profile key rule %d", i+1)
+			}
+		}
+
+		for key, value := range rule.Keys {
+			if err := validateProfileKey(key, value); err != nil {
+				return ConfigError(err, "invalid key in profile key rule %d", i+1)
+			}
+		}
+
+		_, hasSourceProfile := rule.Keys["source_profile"]
+		if _, ok := rule.Keys["credential_source"]; ok && hasSourceProfile {
+			return ConfigError(nil, "profile key rule %d sets both source_profile and credential_source", i+1)
+		}
+	}
+
+	return nil
+}
+
+// ValidateProfileKeys validates keys that are set in generated profiles other than by rules, e.g. from flags
+func ValidateProfileKeys(keys map[string]string) error {
+	for key, value := range keys {
+		if err := validateProfileKey(key, value); err != nil {
+			return ConfigError(err, "invalid profile key %s", key)
+		}
+	}
+
+	return nil
+}
+
+func validateProfileKey(key, value string) error {
+	switch {
+	case key == "" || strings.ContainsAny(key, "=[]# \t\r\n"):
+		return fmt.Errorf("%q is not a valid key", key)
+	case generatedProfileKeys[key]:
+		return fmt.Errorf("%s is set by the generator", key)
+	case strings.ContainsAny(value, "\r\n"):
+		return fmt.Errorf("the value of %s contains a line break", key)
+	case key == "source_profile" && value == "":
+		return fmt.Errorf("source_profile must not be empty")
+	case key == "credential_source" && !slices.Contains(CredentialSources, value):
+		return fmt.Errorf("credential_source must be one of %s, got %q", strings.Join(CredentialSources, ", "), value)
+	case key == "duration_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < minDurationSeconds || seconds > maxDurationSeconds {
+			return fmt.Errorf("duration_seconds must be between %d and %d, got %q",
+				minDurationSeconds, maxDurationSeconds, value)
+		}
+	}
+
+	return nil
+}
+
+// NeedsAccountDetails reports whether a rule matches OUs or tags, which require AWSContext.WithAccountDetails
+func (r ProfileKeyRules) NeedsAccountDetails() bool {
+	for _, rule := range r {
+		if rule.Match.NeedsAccountDetails() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SourceProfiles returns the source profiles set by the rules in the order they appear
+func (r ProfileKeyRules) SourceProfiles() []string {
+	var sourceProfiles []string
+
+	seen := map[string]bool{}
+
+	for _, rule := range r {
+		if sourceProfile, ok := rule.Keys["source_profile"]; ok && !seen[sourceProfile] {
+			sourceProfiles = append(sourceProfiles, sourceProfile)
+			seen[sourceProfile] = true
+		}
+	}
+
+	return sourceProfiles
+}
+
+// Keys returns the keys of all rules matching the profile, matched like the filters are, but with the stage of the
+// profile.
+func (r ProfileKeyRules) Keys(inventory *Inventory, profile Profile, aliases Aliases, stages *StageModel) map[string]string {
+	role, err := arn.Parse(profile.RoleArn)
+	if err != nil {
+		return nil
+	}
+
+	if stages == nil {
+		stages = DefaultStageModel()
+	}
+
+	// the stage of the profile was matched against the account name after name rules, or against the profile name
+	target := newFilterTarget(inventory, role, aliases, nil, stages)
+	target.stage = profile.Stage
+
+	keys := map[string]string{}
+
+	for _, rule := range r {
+		if !rule.Match.matches(target) {
+			continue
+		}
+
+		// a profile has either a source profile or a credential source, whichever was set last
+		if _, ok := rule.Keys["source_profile"]; ok {
+			delete(keys, "credential_source")
+		}
+
+		if _, ok := rule.Keys["credential_source"]; ok {
+			delete(keys, "source_profile")
+		}
+
+		for key, value := range rule.Keys {
+			keys[key] = value
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfileKeyRulesCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   ProfileKeyRules
+		wantErr bool
+	}{
+		{name: "valid", rules: ProfileKeyRules{{Keys: map[string]string{"duration_seconds": "3600", "output": "json"}}}},
+		{name: "no keys", rules: ProfileKeyRules{{Match: &Filters{IncludeStages: []string{"prd"}}}}, wantErr: true},
+		{name: "generated key", rules: ProfileKeyRules{{Keys: map[string]string{"role_arn": "arn"}}}, wantErr: true},
+		{name: "invalid key", rules: ProfileKeyRules{{Keys: map[string]string{"cli pager": ""}}}, wantErr: true},
+		{name: "line break", rules: ProfileKeyRules{{Keys: map[string]string{"output": "json\n[x]"}}}, wantErr: true},
+		{name: "short duration", rules: ProfileKeyRules{{Keys: map[string]string{"duration_seconds": "60"}}}, wantErr: true},
+		{name: "invalid duration", rules: ProfileKeyRules{{Keys: map[string]string{"duration_seconds": "1h"}}}, wantErr: true},
+		{name: "empty source profile", rules: ProfileKeyRules{{Keys: map[string]string{"source_profile": ""}}}, wantErr: true},
+		{name: "invalid credential source", rules: ProfileKeyRules{{Keys: map[string]string{"credential_source": "Ec2"}}}, wantErr: true},
+		{name: "source profile and credential source", rules: ProfileKeyRules{{Keys: map[string]string{
+			"source_profile":    "production",
+			"credential_source": "EcsContainer",
+		}}}, wantErr: true},
+		{name: "invalid match", rules: ProfileKeyRules{{
+			Match: &Filters{IncludeRoles: []string{"["}},
+			Keys:  map[string]string{"output": "json"},
+		}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Compile()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && ErrorKindOf(err) != KindConfig {
+				t.Errorf("Compile() error = %v, want a config error", err)
+			}
+		})
+	}
+}
+
+func TestProfileKeyRulesKeys(t *testing.T) {
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "payments-dev", "67890": "payments-prd"},
+		AccountDetails: map[string]AccountDetails{
+			"67890": {OUPath: "workloads/payments", Tags: map[string]string{"region": "us-east-1"}},
+		},
+	}
+
+	rules := ProfileKeyRules{
+		{Keys: map[string]string{"region": "eu-central-1", "duration_seconds": "3600"}},
+		{Match: &Filters{IncludeStages: []string{"prd"}}, Keys: map[string]string{"duration_seconds": "900"}},
+		{Match: &Filters{IncludeTags: []string{"region=us-*"}}, Keys: map[string]string{"region": "us-east-1"}},
+		{Match: &Filters{IncludeOUs: []string{"workloads"}, ExcludeRoles: []string{"read*"}}, Keys: map[string]string{
+			"source_profile": "production",
+		}},
+	}
+	if err := rules.Compile(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		want    map[string]string
+	}{
+		{
+			name:    "defaults",
+			profile: Profile{RoleArn: "arn:aws:iam::12345:role/admin", Stage: "dev"},
+			want:    map[string]string{"region": "eu-central-1", "duration_seconds": "3600"},
+		},
+		{
+			name:    "later rules override earlier ones",
+			profile: Profile{RoleArn: "arn:aws:iam::67890:role/admin", Stage: "prd"},
+			want:    map[string]string{"region": "us-east-1", "duration_seconds": "900", "source_profile": "production"},
+		},
+		{
+			name:    "excluded role",
+			profile: Profile{RoleArn: "arn:aws:iam::67890:role/readonly", Stage: "prd"},
+			want:    map[string]string{"region": "us-east-1", "duration_seconds": "900"},
+		},
+		{
+			name:    "stage of the profile",
+			profile: Profile{RoleArn: "arn:aws:iam::12345:role/admin", Stage: "prd"},
+			want:    map[string]string{"region": "eu-central-1", "duration_seconds": "900"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Keys(inventory, tt.profile, nil, nil); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Keys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := rules.SourceProfiles(); !reflect.DeepEqual(got, []string{"production"}) {
+		t.Errorf("SourceProfiles() = %v", got)
+	}
+}
+
+func TestProfileKeyRulesCredentialSource(t *testing.T) {
+	inventory := &Inventory{Accounts: map[string]string{"12345": "payments-dev"}}
+
+	rules := ProfileKeyRules{
+		{Keys: map[string]string{"credential_source": "Ec2InstanceMetadata"}},
+		{Match: &Filters{IncludeRoles: []string{"admin"}}, Keys: map[string]string{"source_profile": "production"}},
+	}
+	if err := rules.Compile(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := rules.Keys(inventory, Profile{RoleArn: "arn:aws:iam::12345:role/admin"}, nil, nil)
+	if want := map[string]string{"source_profile": "production"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+
+	got = rules.Keys(inventory, Profile{RoleArn: "arn:aws:iam::12345:role/developer"}, nil, nil)
+	if want := map[string]string{"credential_source": "Ec2InstanceMetadata"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/rs/zerolog/log"
+)
+
+// RecordingVersion is the version of the file format written by --record
+const RecordingVersion = 1
+
+// Recording holds AWS API calls, so that discovery can be replayed without calling AWS. It contains the parameters
+// and results of the calls only, never credentials or signatures, and can be edited to redact sensitive data.
+type Recording struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Service   string          `json:"service"`
+	Operation string          `json:"operation"`
+	Params    json.RawMessage `json:"params"`
+	Output    json.RawMessage `json:"output,omitempty"`
+	Error     *RecordedError  `json:"error,omitempty"`
+}
+
+type RecordedError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+func (i Interaction) key() string {
+	return i.Service + "/" + i.Operation + "/" + string(i.Params)
+}
+
+func newInteraction(r *request.Request) (Interaction, error) {
+	params, err := json.Marshal(r.Params)
+
+	return Interaction{
+		Service:   r.ClientInfo.ServiceName,
+		Operation: r.Operation.Name,
+		Params:    params,
+	}, err
+}
+
+// recorder captures every completed request into a recording file
+type recorder struct {
+	mu        sync.Mutex
+	path      string
+	recording Recording
+}
+
+func newRecorder(path string) *recorder {
+	return &recorder{path: path, recording: Recording{Version: RecordingVersion}}
+}
+
+func (rec *recorder) install(handlers *request.Handlers) {
+	handlers.Complete.PushBack(rec.record)
+}
+
+func (rec *recorder) record(r *request.Request) {
+	interaction, err := newInteraction(r)
+	if err != nil {
+		log.Warn().Err(err).Str("operation", r.Operation.Name).Msg("could not record request")
+		return
+	}
+
+	if r.Error != nil {
+		interaction.Error = &RecordedError{Code: "Unknown", Message: r.Error.Error()}
+
+		var aerr awserr.Error
+		if errors.As(r.Error, &aerr) {
+			interaction.Error.Code = aerr.Code()
+			interaction.Error.Message = aerr.Message()
+		}
+
+		var rerr awserr.RequestFailure
+		if errors.As(r.Error, &rerr) {
+			interaction.Error.StatusCode = rerr.StatusCode()
+		}
+	} else if interaction.Output, err = json.Marshal(r.Data); err != nil {
+		log.Warn().Err(err).Str("operation", r.Operation.Name).Msg("could not record response")
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.recording.Interactions = append(rec.recording.Interactions, interaction)
+
+	// the file is rewritten after every call, so that the calls leading up to a failure are captured as well
+	content, err := json.MarshalIndent(rec.recording, "", "  ")
+	if err == nil {
+		err = os.WriteFile(rec.path, append(content, '\n'), 0o600)
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Str("file-path", rec.path).Msg("could not write recording")
+	}
+}
+
+// replayer serves recorded responses instead of calling AWS
+type replayer struct {
+	interactions map[string]Interaction
+}
+
+func loadReplayer(path string) (*replayer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, IOError(err, "could not read recording %s", path)
+	}
+
+	var recording Recording
+
+	err = json.Unmarshal(content, &recording)
+	if err != nil {
+		return nil, ConfigError(err, "could not parse recording %s", path)
+	}
+
+	if recording.Version != RecordingVersion {
+		return nil, ConfigError(nil, "recording %s has version %d, but only version %d is supported",
+			path, recording.Version, RecordingVersion)
+	}
+
+	rp := &replayer{interactions: map[string]Interaction{}}
+
+	for _, interaction := range recording.Interactions {
+		// params are compacted so that hand-edited recordings still match
+		var params bytes.Buffer
+		if err := json.Compact(&params, interaction.Params); err != nil {
+			return nil, ConfigError(err, "invalid params of %s in recording %s", interaction.Operation, path)
+		}
+
+		interaction.Params = params.Bytes()
+
+		if _, ok := rp.interactions[interaction.key()]; !ok {
+			rp.interactions[interaction.key()] = interaction
+		}
+	}
+
+	return rp, nil
+}
+
+// install replaces signing, sending and unmarshalling of requests with the lookup of a recorded response
+func (rp *replayer) install(handlers *request.Handlers) {
+	handlers.Sign.Clear()
+	handlers.Send.Clear()
+	handlers.UnmarshalMeta.Clear()
+	handlers.ValidateResponse.Clear()
+	handlers.UnmarshalError.Clear()
+	handlers.Unmarshal.Clear()
+
+	handlers.Send.PushBack(rp.replay)
+}
+
+func (rp *replayer) replay(r *request.Request) {
+	lookup, err := newInteraction(r)
+	if err != nil {
+		r.Error = err
+		return
+	}
+
+	interaction, ok := rp.interactions[lookup.key()]
+	if !ok {
+		r.Error = awserr.New("ReplayNotFound", "no recorded response for "+lookup.key(), nil)
+		return
+	}
+
+	statusCode := http.StatusOK
+	if interaction.Error != nil && interaction.Error.StatusCode != 0 {
+		statusCode = interaction.Error.StatusCode
+	}
+
+	r.HTTPResponse = &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{},
+		Body:       io.NopCloser(&bytes.Buffer{}),
+	}
+
+	if interaction.Error != nil {
+		r.Error = awserr.NewRequestFailure(
+			awserr.New(interaction.Error.Code, interaction.Error.Message, nil), statusCode, "replayed")
+		return
+	}
+
+	if err := json.Unmarshal(interaction.Output, r.Data); err != nil {
+		r.Error = awserr.New(request.ErrCodeSerialization, "could not unmarshal recorded response", err)
+	}
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func recorded(t *testing.T, service, operation string, params, output interface{}) Interaction {
+	t.Helper()
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawOutput, err := json.Marshal(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Interaction{Service: service, Operation: operation, Params: rawParams, Output: rawOutput}
+}
+
+func writeRecording(t *testing.T, path string, interactions ...Interaction) {
+	t.Helper()
+
+	content, err := json.Marshal(Recording{Version: RecordingVersion, Interactions: interactions})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayAndRecord(t *testing.T) {
+	dir := t.TempDir()
+	replayPath := filepath.Join(dir, "replay.json")
+	recordPath := filepath.Join(dir, "record.json")
+
+	accessDenied := Interaction{
+		Service:   organizations.ServiceName,
+		Operation: "ListAccounts",
+		Params:    json.RawMessage(`{"MaxResults":null,"NextToken":null}`),
+		Error:     &RecordedError{Code: "AccessDeniedException", Message: "not allowed", StatusCode: 400},
+	}
+
+	writeRecording(t, replayPath,
+		recorded(t, sts.ServiceName, "GetCallerIdentity",
+			sts.GetCallerIdentityInput{},
+			sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::11111:user/jane")}),
+		recorded(t, iam.ServiceName, "ListGroupsForUser",
+			iam.ListGroupsForUserInput{UserName: aws.String("jane")},
+			iam.ListGroupsForUserOutput{Groups: []*iam.Group{{GroupName: aws.String("admins")}}}),
+		recorded(t, iam.ServiceName, "ListGroupPolicies",
+			iam.ListGroupPoliciesInput{GroupName: aws.String("admins")},
+			iam.ListGroupPoliciesOutput{PolicyNames: []*string{aws.String("admin-access")}}),
+		recorded(t, iam.ServiceName, "GetGroupPolicy",
+			iam.GetGroupPolicyInput{GroupName: aws.String("admins"), PolicyName: aws.String("admin-access")},
+			iam.GetGroupPolicyOutput{PolicyDocument: policyDocument("arn:aws:iam::12345:role/admin")}),
+		recorded(t, iam.ServiceName, "ListAttachedGroupPolicies",
+			iam.ListAttachedGroupPoliciesInput{GroupName: aws.String("admins")},
+			iam.ListAttachedGroupPoliciesOutput{}),
+		accessDenied,
+	)
+
+	awsContext, err := GetAWSContext(AWSConfig{Replay: replayPath, Record: recordPath})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	inventory, err := awsContext.GetRolesAndAccounts("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(inventory.RoleArns) != 1 || inventory.RoleArns[0] != "arn:aws:iam::12345:role/admin" {
+		t.Errorf("GetRolesAndAccounts() roles = %v", inventory.RoleArns)
+	}
+
+	content, err := os.ReadFile(recordPath)
+	if err != nil {
+		t.Fatalf("expected a recording: %s", err)
+	}
+
+	var recording Recording
+	if err := json.Unmarshal(content, &recording); err != nil {
+		t.Fatal(err)
+	}
+
+	var operations []string
+	for _, interaction := range recording.Interactions {
+		operations = append(operations, interaction.Operation)
+
+		if interaction.Operation == "ListAccounts" && (interaction.Error == nil || *interaction.Error != *accessDenied.Error) {
+			t.Errorf("expected the recorded error %v, got %v", accessDenied.Error, interaction.Error)
+		}
+	}
+
+	if len(operations) != 6 {
+		t.Errorf("expected every call to be recorded, got %v", operations)
+	}
+}
+
+func TestReplayMissingResponse(t *testing.T) {
+	replayPath := filepath.Join(t.TempDir(), "replay.json")
+	writeRecording(t, replayPath)
+
+	awsContext, err := GetAWSContext(AWSConfig{Replay: replayPath})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := awsContext.GetRolesAndAccounts(""); err == nil {
+		t.Errorf("expected an error for a call that was not recorded")
+	}
+}
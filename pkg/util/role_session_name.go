@@ -0,0 +1,119 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/rs/zerolog/log"
+)
+
+// SessionNameData holds the fields available in a role session name template
+type SessionNameData struct {
+	// the name of the IAM user, or the session name if the caller is an assumed role session
+	UserName string
+	// the value of the email tag of the IAM user, empty if it has none
+	Email string
+	// the tags of the IAM user
+	Tags      map[string]string
+	AccountID string
+	// the host name of the machine running aws-cfg-generator
+	Hostname string
+}
+
+// SessionNameTemplate renders the role_session_name of generated profiles from a Go template, e.g.
+// {{.UserName}}@{{.Hostname}}
+type SessionNameTemplate struct {
+	text     string
+	template *template.Template
+}
+
+const (
+	// the limits of the length of a role session name accepted by STS
+	minSessionNameLength = 2
+	maxSessionNameLength = 64
+)
+
+// invalidSessionNameCharacters are the characters STS doesn't accept in role session names
+var invalidSessionNameCharacters = regexp.MustCompile(`[^\w+=,.@-]+`)
+
+// ParseSessionNameTemplate parses a role session name template, which may use the fields of SessionNameData and the
+// functions of profile name templates
+func ParseSessionNameTemplate(text string) (*SessionNameTemplate, error) {
+	tmpl, err := template.New("role-session-name").Funcs(profileNameFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, ConfigError(err, "invalid role session name template")
+	}
+
+	return &SessionNameTemplate{text: text, template: tmpl}, nil
+}
+
+// NeedsUserTags reports whether the template uses the tags of the IAM user, which have to be looked up
+func (t *SessionNameTemplate) NeedsUserTags() bool {
+	return strings.Contains(t.text, ".Email") || strings.Contains(t.text, ".Tags")
+}
+
+// Render renders the template and sanitizes the result so that STS accepts it
+func (t *SessionNameTemplate) Render(data SessionNameData) (string, error) {
+	var name bytes.Buffer
+
+	if err := t.template.Execute(&name, data); err != nil {
+		return "", ConfigError(err, "could not render role session name of %s", data.UserName)
+	}
+
+	sanitized := SanitizeSessionName(name.String())
+	if len(sanitized) < minSessionNameLength {
+		return "", ConfigError(nil, "role session name %q rendered from %q is shorter than %d characters",
+			sanitized, t.text, minSessionNameLength)
+	}
+
+	return sanitized, nil
+}
+
+// SanitizeSessionName replaces the characters that STS doesn't accept in role session names with dashes and cuts the
+// name to 64 characters
+func SanitizeSessionName(name string) string {
+	name = invalidSessionNameCharacters.ReplaceAllString(strings.TrimSpace(name), "-")
+
+	if len(name) > maxSessionNameLength {
+		name = name[:maxSessionNameLength]
+	}
+
+	return name
+}
+
+// NewSessionNameData returns the fields of a role session name template that are known from the ARN of the caller,
+// and the host name
+func NewSessionNameData(callerArn string) SessionNameData {
+	var data SessionNameData
+
+	if parsed, err := arn.Parse(callerArn); err == nil {
+		data.AccountID = parsed.AccountID
+		data.UserName = parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not get the host name for the role session name")
+	}
+
+	data.Hostname = hostname
+
+	return data
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSessionNameTemplate(t *testing.T) {
+	data := SessionNameData{
+		UserName:  "jane",
+		Email:     "jane.doe+aws@example.com",
+		Tags:      map[string]string{"team": "Payments Core"},
+		AccountID: "11111",
+		Hostname:  "build agent/7",
+	}
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{text: "{{.UserName}}@{{.Hostname}}", want: "jane@build-agent-7"},
+		{text: "{{.Email}}", want: "jane.doe+aws@example.com"},
+		{text: `{{index .Tags "team" | lower}}-{{.UserName}}`, want: "payments-core-jane"},
+		{text: "{{.UserName}}-" + strings.Repeat("x", 100), want: "jane-" + strings.Repeat("x", 59)},
+		{text: "{{.Unknown}}", wantErr: true},
+		{text: `{{index .Tags "missing"}}`, wantErr: true},
+		{text: "ü", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			sessionNameTemplate, err := ParseSessionNameTemplate(tt.text)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := sessionNameTemplate.Render(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && ErrorKindOf(err) != KindConfig {
+				t.Errorf("Render() error = %v, want a config error", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseSessionNameTemplate("{{.UserName"); ErrorKindOf(err) != KindConfig {
+		t.Errorf("ParseSessionNameTemplate() error = %v, want a config error", err)
+	}
+}
+
+func TestSessionNameTemplateNeedsUserTags(t *testing.T) {
+	for text, want := range map[string]bool{
+		"{{.UserName}}@{{.Hostname}}": false,
+		"{{.Email}}":                  true,
+		`{{index .Tags "team"}}`:      true,
+	} {
+		sessionNameTemplate, err := ParseSessionNameTemplate(text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if got := sessionNameTemplate.NeedsUserTags(); got != want {
+			t.Errorf("NeedsUserTags() of %q = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestNewSessionNameData(t *testing.T) {
+	for callerArn, want := range map[string]string{
+		"arn:aws:iam::11111:user/jane":                                    "jane",
+		"arn:aws:iam::11111:user/teams/payments/jane":                     "jane",
+		"arn:aws:sts::11111:assumed-role/build-agent/i-0123456789abcdef0": "i-0123456789abcdef0",
+	} {
+		data := NewSessionNameData(callerArn)
+		if data.UserName != want || data.AccountID != "11111" {
+			t.Errorf("NewSessionNameData(%s) = %+v, want user name %s", callerArn, data, want)
+		}
+	}
+}
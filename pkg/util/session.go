@@ -0,0 +1,164 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/rs/zerolog/log"
+)
+
+// the region used to sign requests against custom endpoints of global services when no region is configured
+const defaultSigningRegion = "us-east-1"
+
+// AWSConfig configures how the clients of an AWSContext talk to AWS
+type AWSConfig struct {
+	// Overrides the region resolved from the environment and shared config
+	Region string
+	// Path to a PEM encoded CA bundle used to verify TLS connections, e.g. to a local stand-in with a self-signed cert
+	CABundle string
+	// Custom endpoint URLs keyed by the SDK's endpoint ID (e.g. sts.EndpointsID)
+	Endpoints map[string]string
+	// The maximum duration of a single API call, unlimited if 0
+	CallTimeout time.Duration
+	// Path of a file every API call and its result is recorded to
+	Record string
+	// Path of a recording whose responses are served instead of calling AWS
+	Replay string
+}
+
+func (cfg AWSConfig) validate() error {
+	for service, endpoint := range cfg.Endpoints {
+		if endpoint == "" {
+			continue
+		}
+
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return ConfigError(err, "invalid %s endpoint %q", service, endpoint)
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return ConfigError(nil, "invalid %s endpoint %q: scheme must be http or https", service, endpoint)
+		}
+	}
+
+	return nil
+}
+
+// endpointResolver returns the custom endpoint for overridden services and falls back to the SDK's defaults
+func (cfg AWSConfig) endpointResolver() endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		endpoint, ok := cfg.Endpoints[service]
+		if !ok || endpoint == "" {
+			return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		}
+
+		signingRegion := region
+		if signingRegion == "" {
+			signingRegion = defaultSigningRegion
+		}
+
+		log.Debug().Str("service", service).Str("endpoint", endpoint).Msg("using custom endpoint")
+
+		return endpoints.ResolvedEndpoint{
+			URL:                endpoint,
+			SigningRegion:      signingRegion,
+			SigningNameDerived: true,
+		}, nil
+	})
+}
+
+func newSession(cfg AWSConfig) (*session.Session, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	opts := session.Options{
+		Config: aws.Config{
+			EndpointResolver: cfg.endpointResolver(),
+		},
+	}
+
+	if cfg.Region != "" {
+		opts.Config.Region = aws.String(cfg.Region)
+	}
+
+	if cfg.Replay != "" {
+		// replayed requests are neither sent nor signed, but the SDK still needs a region to build them
+		if cfg.Region == "" {
+			opts.Config.Region = aws.String(defaultSigningRegion)
+		}
+
+		opts.Config.MaxRetries = aws.Int(0)
+	}
+
+	if cfg.CABundle != "" {
+		caBundle, err := os.Open(cfg.CABundle)
+		if err != nil {
+			return nil, IOError(err, "could not open CA bundle %s", cfg.CABundle)
+		}
+		defer caBundle.Close()
+
+		opts.CustomCABundle = caBundle
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, awsError(err, "could not create AWS session")
+	}
+
+	return sess, nil
+}
+
+// instrument installs the recorder and replayer configured in cfg on the handlers of each client
+func (cfg AWSConfig) instrument(handlers ...*request.Handlers) error {
+	var rp *replayer
+
+	if cfg.Replay != "" {
+		var err error
+
+		rp, err = loadReplayer(cfg.Replay)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("file-path", cfg.Replay).Msg("Replaying recorded AWS API calls")
+	}
+
+	var rec *recorder
+
+	if cfg.Record != "" {
+		rec = newRecorder(cfg.Record)
+		log.Info().Str("file-path", cfg.Record).Msg("Recording AWS API calls")
+	}
+
+	for _, h := range handlers {
+		if rp != nil {
+			rp.install(h)
+		}
+
+		if rec != nil {
+			rec.install(h)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestNewSessionEndpoints(t *testing.T) {
+	sess, err := newSession(AWSConfig{
+		Region: "eu-central-1",
+		Endpoints: map[string]string{
+			sts.EndpointsID: "http://localhost:5000",
+			iam.EndpointsID: "",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got := sts.New(sess).Endpoint; got != "http://localhost:5000" {
+		t.Errorf("sts endpoint = %s, want the custom endpoint", got)
+	}
+
+	if got := iam.New(sess).Endpoint; got != "https://iam.amazonaws.com" {
+		t.Errorf("iam endpoint = %s, want the default endpoint", got)
+	}
+}
+
+func TestNewSessionInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  AWSConfig
+		want ErrorKind
+	}{
+		{name: "endpoint without scheme",
+			cfg:  AWSConfig{Endpoints: map[string]string{sts.EndpointsID: "localhost:5000"}},
+			want: KindConfig},
+		{name: "missing CA bundle",
+			cfg:  AWSConfig{CABundle: "/does/not/exist.pem"},
+			want: KindIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSession(tt.cfg)
+			if got := ErrorKindOf(err); got != tt.want {
+				t.Errorf("newSession() error kind = %s, want %s (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"path"
+	"strings"
+)
+
+// Stage is a deployment stage of accounts, like dev or prd
+type Stage struct {
+	Name string `json:"name"`
+	// Account or profile names ending in one of these belong to the stage, defaults to the name of the stage
+	Suffixes []string `json:"suffixes"`
+	// Accounts with one of these tags belong to the stage, given as key=value where the value may be a glob
+	Tags []string `json:"tags"`
+	// Accounts in one of these OU paths or below belong to the stage, given as globs like workloads/*/prod
+	OUs []string `json:"ous"`
+	// The position of the stage in the deployment pipeline, defaults to the position in the list of stages
+	Rank int `json:"rank"`
+	// The hexcode color of the stage in aws-extend-switch-roles
+	Color string `json:"color"`
+}
+
+// StageModel is the single source of truth for which profiles belong to a stage, used for ordering, coloring,
+// filtering and profile name templates alike
+type StageModel struct {
+	stages []Stage
+	// if set, a suffix only counts if it is preceded by one of these
+	separators []string
+}
+
+func defaultStages() []Stage {
+	return []Stage{{Name: "poc"}, {Name: "dev"}, {Name: "int"}, {Name: "stg"}, {Name: "prd"}, {Name: "global"}}
+}
+
+// DefaultStageModel returns the stages poc, dev, int, stg, prd and global in this order, matched by suffix
+func DefaultStageModel() *StageModel {
+	model, _ := NewStageModel(defaultStages(), nil)
+	return model
+}
+
+// DefaultStageModelWithColors returns the default stages colored with devColor for poc and dev, intColor for int and stg
+// and prdColor for prd and global
+func DefaultStageModelWithColors(devColor, intColor, prdColor string) (*StageModel, error) {
+	colors := map[string]string{
+		"poc": devColor, "dev": devColor,
+		"int": intColor, "stg": intColor,
+		"prd": prdColor, "global": prdColor,
+	}
+
+	stages := defaultStages()
+	for i := range stages {
+		stages[i].Color = colors[stages[i].Name]
+	}
+
+	return NewStageModel(stages, nil)
+}
+
+// NewStageModel validates the stages and fills in the defaults of suffixes and ranks. Stages are matched in the order
+// they are listed, first by tags and OUs, then by suffixes.
+func NewStageModel(stages []Stage, separators []string) (*StageModel, error) {
+	model := &StageModel{separators: separators}
+	names := map[string]bool{}
+
+	for i, stage := range stages {
+		if stage.Name == "" {
+			return nil, ConfigError(nil, "stage %d has no name", i+1)
+		}
+
+		if names[stage.Name] {
+			return nil, ConfigError(nil, "stage %s is defined more than once", stage.Name)
+		}
+
+		names[stage.Name] = true
+
+		if stage.Color != "" && !hexColor.MatchString(stage.Color) {
+			return nil, ConfigError(nil, "color %q of stage %s is not a hexcode color", stage.Color, stage.Name)
+		}
+
+		patterns := append([]string{}, stage.OUs...)
+		for _, tag := range stage.Tags {
+			if _, value, ok := strings.Cut(tag, "="); ok {
+				patterns = append(patterns, value)
+			}
+		}
+
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, ConfigError(err, "invalid pattern %q of stage %s", pattern, stage.Name)
+			}
+		}
+
+		if len(stage.Suffixes) == 0 {
+			stage.Suffixes = []string{stage.Name}
+		}
+
+		if stage.Rank == 0 {
+			stage.Rank = i + 1
+		}
+
+		model.stages = append(model.stages, stage)
+	}
+
+	return model, nil
+}
+
+// NeedsAccountDetails reports whether stages are matched by OUs or tags, which require AWSContext.WithAccountDetails
+func (m *StageModel) NeedsAccountDetails() bool {
+	if m == nil {
+		return false
+	}
+
+	for _, stage := range m.stages {
+		if len(stage.Tags)+len(stage.OUs) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stage returns the stage with the given name, or nil if there is none
+func (m *StageModel) Stage(name string) *Stage {
+	for i := range m.stages {
+		if m.stages[i].Name == name {
+			return &m.stages[i]
+		}
+	}
+
+	return nil
+}
+
+// Rank returns the rank of the stage with the given name, or 0 if there is none
+func (m *StageModel) Rank(name string) int {
+	if stage := m.Stage(name); stage != nil {
+		return stage.Rank
+	}
+
+	return 0
+}
+
+// accountStage returns the stage of an account: the stage of its alias, or the one matching its tags, OUs or its name
+// after nameRules
+func accountStage(inventory *Inventory, accountID string, aliases Aliases, nameRules *NameRules,
+	stages *StageModel) string {
+	if stage := aliases[accountID].Stage; stage != "" {
+		return stage
+	}
+
+	return stages.match(inventory.AccountDetails[accountID], aliases.accountName(inventory, accountID, nameRules))
+}
+
+// match returns the name of the stage of an account, trying its tags and OUs before the suffixes of names
+func (m *StageModel) match(details AccountDetails, names ...string) string {
+	target := filterTarget{ouPath: details.OUPath, tags: details.Tags}
+
+	for _, stage := range m.stages {
+		for _, tag := range stage.Tags {
+			if tagMatches(target, tag) {
+				return stage.Name
+			}
+		}
+
+		for _, ou := range stage.OUs {
+			if details.OUPath != "" && ouMatches(target, ou) {
+				return stage.Name
+			}
+		}
+	}
+
+	for _, name := range names {
+		for _, stage := range m.stages {
+			for _, suffix := range stage.Suffixes {
+				if m.hasSuffix(name, suffix) {
+					return stage.Name
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// trimStage removes the suffix of stage and any separator before it from name, e.g. payments-prd becomes payments
+func (m *StageModel) trimStage(name, stage string) string {
+	if matched := m.Stage(stage); matched != nil {
+		for _, suffix := range matched.Suffixes {
+			if m.hasSuffix(name, suffix) {
+				return strings.TrimRight(name[:len(name)-len(suffix)], "-_. "+strings.Join(m.separators, ""))
+			}
+		}
+	}
+
+	return name
+}
+
+func (m *StageModel) hasSuffix(name, suffix string) bool {
+	name, suffix = strings.ToLower(name), strings.ToLower(suffix)
+
+	if !strings.HasSuffix(name, suffix) {
+		return false
+	}
+
+	if len(m.separators) == 0 || name == suffix {
+		return true
+	}
+
+	for _, separator := range m.separators {
+		if strings.HasSuffix(name, separator+suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestStageModelMatch(t *testing.T) {
+	model, err := NewStageModel([]Stage{
+		{Name: "dev", Suffixes: []string{"dev", "sandbox"}},
+		{Name: "staging", Suffixes: []string{"stg"}, Tags: []string{"stage=stag*"}},
+		{Name: "prd", OUs: []string{"workloads/*/prod"}, Color: "ff0000"},
+	}, []string{"-", "."})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		details AccountDetails
+		names   []string
+		want    string
+	}{
+		{name: "suffix", names: []string{"payments-DEV"}, want: "dev"},
+		{name: "suffix of the name only", names: []string{"prd"}, want: "prd"},
+		{name: "suffix without separator", names: []string{"paymentsdev"}, want: ""},
+		{name: "second name", names: []string{"payments", "payments.sandbox"}, want: "dev"},
+		{name: "tag before suffix",
+			details: AccountDetails{Tags: map[string]string{"stage": "staging"}},
+			names:   []string{"payments-dev"},
+			want:    "staging"},
+		{name: "parent OU",
+			details: AccountDetails{OUPath: "workloads/payments/prod/eu"},
+			names:   []string{"payments"},
+			want:    "prd"},
+		{name: "no stage", names: []string{"tools"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := model.match(tt.details, tt.names...); got != tt.want {
+				t.Errorf("match() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if model.Rank("staging") != 2 || model.Rank("unknown") != 0 {
+		t.Errorf("Rank() of staging = %d, of unknown = %d", model.Rank("staging"), model.Rank("unknown"))
+	}
+
+	if !model.NeedsAccountDetails() || DefaultStageModel().NeedsAccountDetails() {
+		t.Errorf("expected only stages with tags or OUs to need account details")
+	}
+}
+
+func TestNewStageModelErrors(t *testing.T) {
+	for name, stages := range map[string][]Stage{
+		"no name":       {{Suffixes: []string{"dev"}}},
+		"duplicate":     {{Name: "dev"}, {Name: "dev"}},
+		"invalid color": {{Name: "dev", Color: "green"}},
+		"invalid glob":  {{Name: "dev", OUs: []string{"["}}},
+	} {
+		if _, err := NewStageModel(stages, nil); ErrorKindOf(err) != KindConfig {
+			t.Errorf("%s: NewStageModel() error = %v, want a config error", name, err)
+		}
+	}
+}
+
+func TestGetProfilesWithStages(t *testing.T) {
+	model, err := NewStageModel([]Stage{{Name: "prd", Suffixes: []string{"prod"}, Color: "ff0000"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "payments-prod", "67890": "payments-dev"},
+		RoleArns: []string{"arn:aws:iam::12345:role/admin", "arn:aws:iam::67890:role/admin"},
+	}
+
+	profiles, err := GetProfiles("", inventory, ProfileOptions{UseRoleName: true, Stages: model})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the stage comes from the account name, even though the profile name ends in the role name
+	if profiles[0].Stage != "prd" || profiles[0].Color != "ff0000" {
+		t.Errorf("GetProfiles()[0] = %+v, want stage prd", profiles[0])
+	}
+
+	if profiles[1].Stage != "" || profiles[1].Color != "" {
+		t.Errorf("GetProfiles()[1] = %+v, want no stage", profiles[1])
+	}
+}
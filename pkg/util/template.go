@@ -0,0 +1,81 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// ProfileNameData holds the fields available in a profile name template
+type ProfileNameData struct {
+	// the name of the account, or its ID if the name is unknown
+	Account   string
+	AccountID string
+	// the name of the role without its path
+	Role string
+	// the path of the role, e.g. / or /teams/payments/
+	RolePath string
+	// the organizational units containing the account, e.g. workloads/payments
+	OUPath string
+	// the stage the account name ends in, empty if it doesn't end in one
+	Stage string
+	// the tags of the account
+	Tags map[string]string
+	// the first of the groups granting the role, empty for roles added with --role
+	Group string
+	// all groups granting the role
+	Groups []string
+}
+
+// ProfileNameTemplate renders profile names from a Go template, e.g. {{.Stage}}-{{.Account}}-{{.Role | lower}}
+type ProfileNameTemplate struct {
+	text     string
+	template *template.Template
+}
+
+var profileNameFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// ParseProfileNameTemplate parses a profile name template, which may use the fields of ProfileNameData and the
+// functions lower, upper, replace, trimPrefix and trimSuffix
+func ParseProfileNameTemplate(text string) (*ProfileNameTemplate, error) {
+	tmpl, err := template.New("profile-name").Funcs(profileNameFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, ConfigError(err, "invalid profile name template")
+	}
+
+	return &ProfileNameTemplate{text: text, template: tmpl}, nil
+}
+
+// NeedsAccountDetails reports whether the template uses fields that require AWSContext.WithAccountDetails
+func (t *ProfileNameTemplate) NeedsAccountDetails() bool {
+	return strings.Contains(t.text, ".OUPath") || strings.Contains(t.text, ".Tags")
+}
+
+func (t *ProfileNameTemplate) render(data ProfileNameData) (string, error) {
+	var name bytes.Buffer
+
+	if err := t.template.Execute(&name, data); err != nil {
+		return "", ConfigError(err, "could not render profile name of role %s/%s", data.AccountID, data.Role)
+	}
+
+	return name.String(), nil
+}
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetProfilesWithNameTemplate(t *testing.T) {
+	inventory := &Inventory{
+		Accounts: map[string]string{"12345": "payments-prd"},
+		RoleArns: []string{"arn:aws:iam::12345:role/teams/Admin", "arn:aws:iam::67890:role/developer"},
+		Grants: []Grant{
+			{RoleArn: "arn:aws:iam::12345:role/teams/Admin", Group: "developers", Policy: "dev-access"},
+			{RoleArn: "arn:aws:iam::12345:role/teams/Admin", Group: "admins", Policy: "admin-access"},
+		},
+		AccountDetails: map[string]AccountDetails{
+			"12345": {OUPath: "workloads/payments", Tags: map[string]string{"team": "checkout"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{name: "stage, account and role",
+			template: "{{.Stage}}-{{.Account}}-{{.Role | lower}}",
+			want:     []string{"prd-payments-prd-admin", "-67890-developer"}},
+		{name: "account details",
+			template: "{{.OUPath}}/{{.Tags.team}}{{.RolePath}}{{.Role}}",
+			want:     []string{"workloads/payments/checkout/teams/Admin", "//developer"}},
+		{name: "granting group",
+			template: `{{.AccountID}}_{{with .Group}}{{.}}{{else}}org{{end}}`,
+			want:     []string{"12345_admins", "67890_org"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameTemplate, err := ParseProfileNameTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			profiles, err := GetProfiles("", inventory, ProfileOptions{NameTemplate: nameTemplate})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := profileNames(profiles); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetProfiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileNameTemplateErrors(t *testing.T) {
+	if _, err := ParseProfileNameTemplate("{{.Account"); ErrorKindOf(err) != KindConfig {
+		t.Errorf("ParseProfileNameTemplate() error = %v, want a config error", err)
+	}
+
+	inventory := &Inventory{RoleArns: []string{"arn:aws:iam::12345:role/admin"}}
+
+	for _, text := range []string{"{{.Group}}", "[{{.Account}}]", " {{.Role}}", "{{.Unknown}}"} {
+		nameTemplate, err := ParseProfileNameTemplate(text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if _, err := GetProfiles("", inventory, ProfileOptions{NameTemplate: nameTemplate}); ErrorKindOf(err) != KindConfig {
+			t.Errorf("GetProfiles() with template %q error = %v, want a config error", text, err)
+		}
+	}
+}
+
+func TestNeedsAccountDetails(t *testing.T) {
+	for text, want := range map[string]bool{
+		"{{.Account}}-{{.Role}}":  false,
+		"{{.OUPath}}":             true,
+		`{{index .Tags "stage"}}`: true,
+	} {
+		nameTemplate, err := ParseProfileNameTemplate(text)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if got := nameTemplate.NeedsAccountDetails(); got != want {
+			t.Errorf("NeedsAccountDetails() of %q = %v, want %v", text, got, want)
+		}
+	}
+}
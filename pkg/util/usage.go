@@ -0,0 +1,103 @@
+package util
+
+/*
+   Copyright 2021 MOIA GmbH
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/rs/zerolog/log"
+)
+
+// RoleUsage is the time every role was last used, keyed by account ID and role name without its path
+type RoleUsage map[string]time.Time
+
+func usageKey(accountID, roleName string) string {
+	return accountID + "/" + path.Base(roleName)
+}
+
+// lastUsed returns when the role of a profile was last used, or the zero time if it wasn't
+func (u RoleUsage) lastUsed(profile Profile) time.Time {
+	return u[usageKey(profile.AccountID, profile.RoleName)]
+}
+
+// cachedCredentials is the part of a file in the AWS CLI credential cache that identifies the assumed role
+type cachedCredentials struct {
+	AssumedRoleUser struct {
+		Arn string
+	}
+}
+
+// ReadRoleUsage finds out when roles were last used from the credentials the AWS CLI caches whenever it assumes a
+// role, usually in ~/.aws/cli/cache. A missing directory means that no role was used. Roles only used with aws-vault
+// don't show up, as it keeps its sessions in its own keyring.
+func ReadRoleUsage(dir string) (RoleUsage, error) {
+	usage := RoleUsage{}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return usage, nil
+	}
+
+	if err != nil {
+		return nil, IOError(err, "could not read credential cache %s", dir)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Debug().Err(err).Str("file", entry.Name()).Msg("skipping unreadable cached credentials")
+			continue
+		}
+
+		var credentials cachedCredentials
+		if err := json.Unmarshal(content, &credentials); err != nil {
+			continue
+		}
+
+		// arn:aws:sts::123456789012:assumed-role/role-name/session-name
+		assumedRole, err := arn.Parse(credentials.AssumedRoleUser.Arn)
+		if err != nil {
+			continue
+		}
+
+		parts := strings.Split(assumedRole.Resource, "/")
+		if len(parts) != 3 || parts[0] != "assumed-role" {
+			continue
+		}
+
+		key := usageKey(assumedRole.AccountID, parts[1])
+		if info.ModTime().After(usage[key]) {
+			usage[key] = info.ModTime()
+		}
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadRoleUsage(t *testing.T) {
+	dir := t.TempDir()
+	used := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	files := map[string]string{
+		"a.json":   `{"Credentials": {}, "AssumedRoleUser": {"Arn": "arn:aws:sts::12345:assumed-role/admin/botocore-session-1"}}`,
+		"b.json":   `{"AssumedRoleUser": {"Arn": "arn:aws:sts::12345:federated-user/jane"}}`,
+		"c.json":   `not json`,
+		"d.txt":    `{"AssumedRoleUser": {"Arn": "arn:aws:sts::67890:assumed-role/admin/session"}}`,
+		"old.json": `{"AssumedRoleUser": {"Arn": "arn:aws:sts::12345:assumed-role/admin/botocore-session-0"}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		modTime := used
+		if name == "old.json" {
+			modTime = used.Add(-time.Hour)
+		}
+
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := ReadRoleUsage(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(usage) != 1 || !usage.lastUsed(Profile{AccountID: "12345", RoleName: "teams/admin"}).Equal(used) {
+		t.Errorf("ReadRoleUsage() = %v", usage)
+	}
+
+	usage, err = ReadRoleUsage(filepath.Join(dir, "missing"))
+	if err != nil || len(usage) != 0 {
+		t.Errorf("ReadRoleUsage() of a missing directory = %v, %v", usage, err)
+	}
+}